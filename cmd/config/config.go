@@ -0,0 +1,359 @@
+// Package config loads the blog's settings once at startup. Values come
+// from the environment, then an optional .env file, then an optional YAML or
+// TOML file named by CONFIG_FILE, the first one that sets a value wins.
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/joho/godotenv"
+	"github.com/noctispine/blog/pkg/logging"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v2"
+)
+
+const minSecretLength = 32
+
+type Config struct {
+	Server    Server    `yaml:"server" toml:"server"`
+	Database  Database  `yaml:"database" toml:"database"`
+	JWT       JWT       `yaml:"jwt" toml:"jwt"`
+	Mail      Mail      `yaml:"mail" toml:"mail"`
+	SignIn    SignIn    `yaml:"sign_in" toml:"sign_in"`
+	Passwords Passwords `yaml:"passwords" toml:"passwords"`
+	TwoFactor TwoFactor `yaml:"two_factor" toml:"two_factor"`
+	OIDC      OIDC      `yaml:"oidc" toml:"oidc"`
+	Log       Log       `yaml:"log" toml:"log"`
+}
+
+type Server struct {
+	// PROD runs on ProdPort, anything else on DevPort
+	Env            string `env:"APP_ENV" yaml:"env" toml:"env"`
+	DevPort        int    `env:"DEV_PORT" yaml:"dev_port" toml:"dev_port"`
+	ProdPort       int    `env:"PROD_PORT" yaml:"prod_port" toml:"prod_port"`
+	PublicURL      string `env:"PUBLIC_URL" yaml:"public_url" toml:"public_url"`
+	BlogTitle      string `env:"BLOG_TITLE" yaml:"blog_title" toml:"blog_title"`
+	SearchLanguage string `env:"SEARCH_LANGUAGE" yaml:"search_language" toml:"search_language"`
+	// how long in-flight requests get to finish on SIGTERM
+	ShutdownTimeoutSeconds int `env:"SHUTDOWN_TIMEOUT_SECONDS" yaml:"shutdown_timeout_seconds" toml:"shutdown_timeout_seconds"`
+	// how long /readyz fails before the listener closes, so load balancers
+	// stop sending new requests first
+	ShutdownDrainSeconds int `env:"SHUTDOWN_DRAIN_SECONDS" yaml:"shutdown_drain_seconds" toml:"shutdown_drain_seconds"`
+	// IPs or CIDRs of the proxies whose X-Forwarded-For is believed, space
+	// separated. None by default, the client IP is the peer address then.
+	TrustedProxies []string `env:"TRUSTED_PROXIES" yaml:"trusted_proxies" toml:"trusted_proxies"`
+}
+
+// Port returns the port of the environment the server runs in.
+func (s Server) Port() int {
+	if s.Env == "PROD" {
+		return s.ProdPort
+	}
+
+	return s.DevPort
+}
+
+type Database struct {
+	Host     string `env:"DB_HOST" yaml:"host" toml:"host"`
+	Port     int    `env:"DB_PORT" yaml:"port" toml:"port"`
+	User     string `env:"DB_USER" yaml:"user" toml:"user"`
+	Password string `env:"DB_PASSWORD" yaml:"password" toml:"password"`
+	Name     string `env:"DB_NAME" yaml:"name" toml:"name"`
+	SSLMode  string `env:"DB_SSLMODE" yaml:"sslmode" toml:"sslmode"`
+	// queries slower than this are logged as warnings, 0 turns that off
+	SlowQueryMilliseconds int `env:"DB_SLOW_QUERY_MS" yaml:"slow_query_ms" toml:"slow_query_ms"`
+}
+
+// DSN returns the connection string for the postgres driver.
+func (d Database) DSN() string {
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
+		d.Host, d.User, d.Password, d.Name, d.Port, d.SSLMode)
+}
+
+type JWT struct {
+	// signs email verification links, access tokens use the key ring
+	Secret             string `env:"JWT_SECRET" yaml:"secret" toml:"secret"`
+	ExpireMinutes      int    `env:"JWT_EXPIRE_MINUTES" yaml:"expire_minutes" toml:"expire_minutes"`
+	RefreshExpireHours int    `env:"REFRESH_TOKEN_EXPIRE_HOURS" yaml:"refresh_expire_hours" toml:"refresh_expire_hours"`
+	SigningAlgorithm   string `env:"JWT_SIGNING_ALGORITHM" yaml:"signing_algorithm" toml:"signing_algorithm"`
+	KeyRotationHours   int    `env:"JWT_KEY_ROTATION_HOURS" yaml:"key_rotation_hours" toml:"key_rotation_hours"`
+	// encrypts the private signing keys stored in the database
+	SigningKeySecret string `env:"SIGNING_KEY_SECRET" yaml:"signing_key_secret" toml:"signing_key_secret"`
+}
+
+type Mail struct {
+	// "smtp" sends through SMTPHost, anything else writes to OutboxDir
+	Driver       string `env:"MAIL_DRIVER" yaml:"driver" toml:"driver"`
+	From         string `env:"MAIL_FROM" yaml:"from" toml:"from"`
+	OutboxDir    string `env:"MAIL_OUTBOX_DIR" yaml:"outbox_dir" toml:"outbox_dir"`
+	SMTPHost     string `env:"SMTP_HOST" yaml:"smtp_host" toml:"smtp_host"`
+	SMTPPort     string `env:"SMTP_PORT" yaml:"smtp_port" toml:"smtp_port"`
+	SMTPUser     string `env:"SMTP_USER" yaml:"smtp_user" toml:"smtp_user"`
+	SMTPPassword string `env:"SMTP_PASSWORD" yaml:"smtp_password" toml:"smtp_password"`
+}
+
+type SignIn struct {
+	// "postgres" shares the counters between instances
+	Limiter              string `env:"SIGNIN_LIMITER" yaml:"limiter" toml:"limiter"`
+	LockoutSeconds       int    `env:"SIGNIN_LOCKOUT_SECONDS" yaml:"lockout_seconds" toml:"lockout_seconds"`
+	LockoutMaxSeconds    int    `env:"SIGNIN_LOCKOUT_MAX_SECONDS" yaml:"lockout_max_seconds" toml:"lockout_max_seconds"`
+	FailureWindowSeconds int    `env:"SIGNIN_FAILURE_WINDOW_SECONDS" yaml:"failure_window_seconds" toml:"failure_window_seconds"`
+	MaxAttempts          int    `env:"SIGNIN_MAX_ATTEMPTS" yaml:"max_attempts" toml:"max_attempts"`
+	IPMaxAttempts        int    `env:"SIGNIN_IP_MAX_ATTEMPTS" yaml:"ip_max_attempts" toml:"ip_max_attempts"`
+	// password reset requests allowed per email and per client IP before
+	// they are throttled like failed sign ins
+	ResetMaxRequests   int `env:"PASSWORD_RESET_MAX_REQUESTS" yaml:"reset_max_requests" toml:"reset_max_requests"`
+	ResetIPMaxRequests int `env:"PASSWORD_RESET_IP_MAX_REQUESTS" yaml:"reset_ip_max_requests" toml:"reset_ip_max_requests"`
+}
+
+type Passwords struct {
+	// "bcrypt" or "argon2id", hashes of the other one still verify
+	Hasher            string `env:"PASSWORD_HASHER" yaml:"hasher" toml:"hasher"`
+	BcryptCost        int    `env:"BCRYPT_COST" yaml:"bcrypt_cost" toml:"bcrypt_cost"`
+	Argon2MemoryKiB   int    `env:"ARGON2_MEMORY_KIB" yaml:"argon2_memory_kib" toml:"argon2_memory_kib"`
+	Argon2Iterations  int    `env:"ARGON2_ITERATIONS" yaml:"argon2_iterations" toml:"argon2_iterations"`
+	Argon2Parallelism int    `env:"ARGON2_PARALLELISM" yaml:"argon2_parallelism" toml:"argon2_parallelism"`
+}
+
+type TwoFactor struct {
+	// encrypts the TOTP secrets stored in the database
+	EncryptionSecret string `env:"TOTP_ENCRYPTION_SECRET" yaml:"encryption_secret" toml:"encryption_secret"`
+}
+
+type Log struct {
+	// debug, info, warn or error
+	Level string `env:"LOG_LEVEL" yaml:"level" toml:"level"`
+	// json or text
+	Format string `env:"LOG_FORMAT" yaml:"format" toml:"format"`
+}
+
+// OIDC sign in is turned off while IssuerURL is empty.
+type OIDC struct {
+	IssuerURL    string   `env:"OIDC_ISSUER_URL" yaml:"issuer_url" toml:"issuer_url"`
+	ClientID     string   `env:"OIDC_CLIENT_ID" yaml:"client_id" toml:"client_id"`
+	ClientSecret string   `env:"OIDC_CLIENT_SECRET" yaml:"client_secret" toml:"client_secret"`
+	RedirectURL  string   `env:"OIDC_REDIRECT_URL" yaml:"redirect_url" toml:"redirect_url"`
+	Scopes       []string `env:"OIDC_SCOPES" yaml:"scopes" toml:"scopes"`
+}
+
+func defaults() Config {
+	return Config{
+		Server: Server{
+			BlogTitle:              "Blog",
+			SearchLanguage:         "english",
+			ShutdownTimeoutSeconds: 15,
+		},
+		Database: Database{
+			Port:                  5432,
+			SSLMode:               "disable",
+			SlowQueryMilliseconds: 200,
+		},
+		JWT: JWT{
+			ExpireMinutes:      15,
+			RefreshExpireHours: 24 * 30,
+			SigningAlgorithm:   "RS256",
+			KeyRotationHours:   24 * 30,
+		},
+		Mail: Mail{
+			From:      "no-reply@localhost",
+			OutboxDir: "outbox",
+		},
+		SignIn: SignIn{
+			LockoutSeconds:       30,
+			LockoutMaxSeconds:    3600,
+			FailureWindowSeconds: 3600,
+			MaxAttempts:          5,
+			// addresses behind a NAT are shared by many users
+			IPMaxAttempts:      20,
+			ResetMaxRequests:   3,
+			ResetIPMaxRequests: 10,
+		},
+		Passwords: Passwords{
+			Hasher:            "argon2id",
+			BcryptCost:        12,
+			Argon2MemoryKiB:   19456,
+			Argon2Iterations:  2,
+			Argon2Parallelism: 1,
+		},
+		OIDC: OIDC{
+			Scopes: []string{"openid", "email", "profile"},
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+	}
+}
+
+// Load reads and validates the configuration. A missing .env file or an
+// unset CONFIG_FILE is fine, a file that can't be parsed is not.
+func Load() (*Config, error) {
+	dotenv, err := godotenv.Read(".env")
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("reading .env: %w", err)
+	}
+
+	lookup := func(key string) (string, bool) {
+		if value, ok := os.LookupEnv(key); ok {
+			return value, true
+		}
+
+		value, ok := dotenv[key]
+		return value, ok
+	}
+
+	config := defaults()
+
+	if path, ok := lookup("CONFIG_FILE"); ok && path != "" {
+		if err := readFile(path, &config); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := applyEnv(reflect.ValueOf(&config).Elem(), lookup); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return &config, nil
+}
+
+func readFile(path string, config *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.UnmarshalStrict(data, config)
+	case ".toml":
+		err = toml.NewDecoder(bytes.NewReader(data)).DisallowUnknownFields().Decode(config)
+	default:
+		return fmt.Errorf("config file %s has to be .yaml, .yml or .toml", path)
+	}
+
+	if err != nil {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// applyEnv overrides every field with an env tag whose variable is set.
+// Lists are separated by whitespace.
+func applyEnv(section reflect.Value, lookup func(string) (string, bool)) error {
+	for i := 0; i < section.NumField(); i++ {
+		field := section.Field(i)
+		key := section.Type().Field(i).Tag.Get("env")
+
+		if field.Kind() == reflect.Struct {
+			if err := applyEnv(field, lookup); err != nil {
+				return err
+			}
+			continue
+		}
+
+		value, ok := lookup(key)
+		if key == "" || !ok {
+			continue
+		}
+
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Int:
+			number, err := strconv.Atoi(strings.TrimSpace(value))
+			if err != nil {
+				return fmt.Errorf("%s has to be a number", key)
+			}
+			field.SetInt(int64(number))
+		case reflect.Slice:
+			field.Set(reflect.ValueOf(strings.Fields(value)))
+		}
+	}
+
+	return nil
+}
+
+// Validate reports every invalid setting at once.
+func (c *Config) Validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	port := c.Server.Port()
+	check(port > 0 && port <= 65535, "server port %d is out of range, set DEV_PORT or PROD_PORT", port)
+	check(c.Server.ShutdownTimeoutSeconds > 0, "SHUTDOWN_TIMEOUT_SECONDS has to be positive")
+	check(c.Server.ShutdownDrainSeconds >= 0, "SHUTDOWN_DRAIN_SECONDS can't be negative")
+	for _, proxy := range c.Server.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		check(cidrErr == nil || net.ParseIP(proxy) != nil, "TRUSTED_PROXIES entry %q is not an IP or CIDR", proxy)
+	}
+
+	// every instance mails reset and verification links, the outbox driver
+	// included, and they must never be built from request headers
+	parsed, err := url.Parse(c.Server.PublicURL)
+	check(err == nil && parsed.IsAbs() && parsed.Host != "", "PUBLIC_URL is required for emailed links and has to be an absolute URL")
+
+	check(c.Database.Host != "", "DB_HOST is required")
+	check(c.Database.Port > 0 && c.Database.Port <= 65535, "DB_PORT %d is out of range", c.Database.Port)
+	check(c.Database.User != "", "DB_USER is required")
+	check(c.Database.Name != "", "DB_NAME is required")
+	check(c.Database.SlowQueryMilliseconds >= 0, "DB_SLOW_QUERY_MS can't be negative")
+
+	check(len(c.JWT.Secret) >= minSecretLength, "JWT_SECRET has to be at least %d characters", minSecretLength)
+	check(c.JWT.ExpireMinutes > 0, "JWT_EXPIRE_MINUTES has to be positive")
+	check(c.JWT.RefreshExpireHours > 0, "REFRESH_TOKEN_EXPIRE_HOURS has to be positive")
+	check(c.JWT.RefreshExpireHours*60 > c.JWT.ExpireMinutes, "refresh tokens have to outlive access tokens")
+	check(c.JWT.SigningAlgorithm == "RS256" || c.JWT.SigningAlgorithm == "EdDSA", "JWT_SIGNING_ALGORITHM has to be RS256 or EdDSA")
+	check(c.JWT.KeyRotationHours > 0, "JWT_KEY_ROTATION_HOURS has to be positive")
+	check(len(c.JWT.SigningKeySecret) >= minSecretLength, "SIGNING_KEY_SECRET has to be at least %d characters", minSecretLength)
+	check(c.JWT.SigningKeySecret != c.JWT.Secret, "SIGNING_KEY_SECRET has to differ from JWT_SECRET")
+	check(len(c.TwoFactor.EncryptionSecret) >= minSecretLength, "TOTP_ENCRYPTION_SECRET has to be at least %d characters", minSecretLength)
+	check(c.TwoFactor.EncryptionSecret != c.JWT.Secret && c.TwoFactor.EncryptionSecret != c.JWT.SigningKeySecret, "TOTP_ENCRYPTION_SECRET has to differ from JWT_SECRET and SIGNING_KEY_SECRET")
+
+	if c.Mail.Driver == "smtp" {
+		check(c.Mail.SMTPHost != "", "SMTP_HOST is required with MAIL_DRIVER=smtp")
+	}
+
+	check(c.SignIn.LockoutSeconds > 0 && c.SignIn.LockoutMaxSeconds >= c.SignIn.LockoutSeconds, "SIGNIN_LOCKOUT_SECONDS has to be positive and at most SIGNIN_LOCKOUT_MAX_SECONDS")
+	check(c.SignIn.FailureWindowSeconds > 0, "SIGNIN_FAILURE_WINDOW_SECONDS has to be positive")
+	check(c.SignIn.MaxAttempts > 0 && c.SignIn.IPMaxAttempts > 0, "SIGNIN_MAX_ATTEMPTS and SIGNIN_IP_MAX_ATTEMPTS have to be positive")
+	check(c.SignIn.ResetMaxRequests > 0 && c.SignIn.ResetIPMaxRequests > 0, "PASSWORD_RESET_MAX_REQUESTS and PASSWORD_RESET_IP_MAX_REQUESTS have to be positive")
+
+	check(c.Passwords.Hasher == "argon2id" || c.Passwords.Hasher == "bcrypt", "PASSWORD_HASHER has to be argon2id or bcrypt")
+	check(c.Passwords.BcryptCost >= 4 && c.Passwords.BcryptCost <= 31, "BCRYPT_COST has to be between 4 and 31")
+	check(c.Passwords.Argon2MemoryKiB > 0 && c.Passwords.Argon2Iterations > 0, "ARGON2_MEMORY_KIB and ARGON2_ITERATIONS have to be positive")
+	check(c.Passwords.Argon2Parallelism > 0 && c.Passwords.Argon2Parallelism <= 255, "ARGON2_PARALLELISM has to be between 1 and 255")
+
+	if c.OIDC.IssuerURL != "" {
+		check(c.OIDC.ClientID != "", "OIDC_CLIENT_ID is required with OIDC_ISSUER_URL")
+		check(len(c.OIDC.Scopes) > 0, "OIDC_SCOPES can't be empty")
+	}
+
+	_, err = logging.ParseLevel(c.Log.Level)
+	check(err == nil, "LOG_LEVEL has to be debug, info, warn or error")
+	check(c.Log.Format == logging.JSON || c.Log.Format == logging.Text, "LOG_FORMAT has to be json or text")
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  %s", strings.Join(problems, "\n  "))
+	}
+
+	return nil
+}
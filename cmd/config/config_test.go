@@ -0,0 +1,290 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// requiredEnv is the least a valid configuration needs.
+var requiredEnv = map[string]string{
+	"DEV_PORT":               "8080",
+	"PUBLIC_URL":             "https://blog.example.com",
+	"DB_HOST":                "localhost",
+	"DB_USER":                "blog",
+	"DB_NAME":                "blog",
+	"JWT_SECRET":             "0123456789abcdef0123456789abcdef",
+	"SIGNING_KEY_SECRET":     "fedcba9876543210fedcba9876543210",
+	"TOTP_ENCRYPTION_SECRET": "00112233445566778899aabbccddeeff",
+}
+
+// inTempDir runs the test in an empty directory holding files, so Load
+// finds the test's .env and config file only.
+func inTempDir(t *testing.T, files map[string]string) {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		fileName string
+		file     string
+	}{
+		{
+			name:     "yaml",
+			fileName: "blog.yaml",
+			file: `server:
+  blog_title: From the file
+  search_language: german
+database:
+  host: file-host
+  name: file-db
+log:
+  level: warn
+`,
+		},
+		{
+			name:     "toml",
+			fileName: "blog.toml",
+			file: `[server]
+blog_title = "From the file"
+search_language = "german"
+
+[database]
+host = "file-host"
+name = "file-db"
+
+[log]
+level = "warn"
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t, map[string]string{
+				tt.fileName: tt.file,
+				// CONFIG_FILE may come from .env as well
+				".env": "CONFIG_FILE=" + tt.fileName + "\nBLOG_TITLE=From .env\nDB_HOST=dotenv-host\n",
+			})
+			setEnv(t, requiredEnv)
+			t.Setenv("DB_HOST", "env-host")
+
+			config, err := Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			checks := []struct {
+				setting string
+				got     interface{}
+				want    interface{}
+			}{
+				// env beats .env
+				{"DB_HOST", config.Database.Host, "env-host"},
+				// .env beats the file
+				{"BLOG_TITLE", config.Server.BlogTitle, "From .env"},
+				// env beats the file
+				{"DB_NAME", config.Database.Name, "blog"},
+				// the file beats the defaults
+				{"SEARCH_LANGUAGE", config.Server.SearchLanguage, "german"},
+				{"LOG_LEVEL", config.Log.Level, "warn"},
+				// defaults fill the rest
+				{"LOG_FORMAT", config.Log.Format, "json"},
+				{"DB_PORT", config.Database.Port, 5432},
+			}
+
+			for _, check := range checks {
+				if check.got != check.want {
+					t.Errorf("%s = %v, want %v", check.setting, check.got, check.want)
+				}
+			}
+		})
+	}
+}
+
+func TestLoadEnvTypes(t *testing.T) {
+	inTempDir(t, nil)
+	setEnv(t, requiredEnv)
+	t.Setenv("SIGNIN_MAX_ATTEMPTS", " 7 ")
+	t.Setenv("OIDC_SCOPES", "openid  email\tgroups")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8 192.168.1.1")
+
+	config, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.SignIn.MaxAttempts != 7 {
+		t.Errorf("SIGNIN_MAX_ATTEMPTS = %d, want 7", config.SignIn.MaxAttempts)
+	}
+	if want := []string{"openid", "email", "groups"}; !reflect.DeepEqual(config.OIDC.Scopes, want) {
+		t.Errorf("OIDC_SCOPES = %q, want %q", config.OIDC.Scopes, want)
+	}
+	if want := []string{"10.0.0.0/8", "192.168.1.1"}; !reflect.DeepEqual(config.Server.TrustedProxies, want) {
+		t.Errorf("TRUSTED_PROXIES = %q, want %q", config.Server.TrustedProxies, want)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		env   map[string]string
+		want  string
+	}{
+		{
+			name: "not a number",
+			env:  map[string]string{"DB_PORT": "five"},
+			want: "DB_PORT has to be a number",
+		},
+		{
+			name:  "unknown yaml setting",
+			files: map[string]string{"blog.yaml": "server:\n  blog_titel: typo\n"},
+			env:   map[string]string{"CONFIG_FILE": "blog.yaml"},
+			want:  "blog_titel",
+		},
+		{
+			name:  "unknown toml setting",
+			files: map[string]string{"blog.toml": "[server]\nblog_titel = \"typo\"\n"},
+			env:   map[string]string{"CONFIG_FILE": "blog.toml"},
+			want:  "reading blog.toml",
+		},
+		{
+			name:  "unsupported file type",
+			files: map[string]string{"blog.json": "{}"},
+			env:   map[string]string{"CONFIG_FILE": "blog.json"},
+			want:  "has to be .yaml, .yml or .toml",
+		},
+		{
+			name: "missing file",
+			env:  map[string]string{"CONFIG_FILE": "missing.yaml"},
+			want: "missing.yaml",
+		},
+		{
+			name:  "broken .env",
+			files: map[string]string{".env": "JUST_A_WORD\n"},
+			want:  "reading .env",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inTempDir(t, tt.files)
+			setEnv(t, requiredEnv)
+			setEnv(t, tt.env)
+
+			_, err := Load()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(c *Config)
+		// the problem reported, empty when the config is valid
+		want string
+	}{
+		{"valid", func(c *Config) {}, ""},
+		{"production port", func(c *Config) { c.Server.Env = "PROD" }, "server port 0 is out of range"},
+		{"missing public url", func(c *Config) { c.Server.PublicURL = "" }, "PUBLIC_URL is required"},
+		{"relative public url", func(c *Config) { c.Server.PublicURL = "/blog" }, "PUBLIC_URL is required"},
+		{"trusted proxies", func(c *Config) { c.Server.TrustedProxies = []string{"10.0.0.0/8", "::1"} }, ""},
+		{"bad trusted proxy", func(c *Config) { c.Server.TrustedProxies = []string{"proxy.internal"} }, `TRUSTED_PROXIES entry "proxy.internal"`},
+		{"missing database host", func(c *Config) { c.Database.Host = "" }, "DB_HOST is required"},
+		{"short jwt secret", func(c *Config) { c.JWT.Secret = "short" }, "JWT_SECRET has to be at least 32 characters"},
+		{"missing signing key secret", func(c *Config) { c.JWT.SigningKeySecret = "" }, "SIGNING_KEY_SECRET has to be at least 32 characters"},
+		{"shared secrets", func(c *Config) { c.JWT.SigningKeySecret = c.JWT.Secret }, "SIGNING_KEY_SECRET has to differ"},
+		{"missing totp encryption secret", func(c *Config) { c.TwoFactor.EncryptionSecret = "" }, "TOTP_ENCRYPTION_SECRET has to be at least 32 characters"},
+		{"totp secret shared with signing keys", func(c *Config) { c.TwoFactor.EncryptionSecret = c.JWT.SigningKeySecret }, "TOTP_ENCRYPTION_SECRET has to differ"},
+		{"refresh shorter than access", func(c *Config) { c.JWT.ExpireMinutes = 60 * 24 * 31 }, "refresh tokens have to outlive access tokens"},
+		{"signing algorithm", func(c *Config) { c.JWT.SigningAlgorithm = "HS256" }, "JWT_SIGNING_ALGORITHM"},
+		{"smtp without host", func(c *Config) { c.Mail.Driver = "smtp" }, "SMTP_HOST is required"},
+		{"lockout longer than its maximum", func(c *Config) { c.SignIn.LockoutSeconds = 7200 }, "SIGNIN_LOCKOUT_SECONDS"},
+		{"no password resets", func(c *Config) { c.SignIn.ResetMaxRequests = 0 }, "PASSWORD_RESET_MAX_REQUESTS"},
+		{"password hasher", func(c *Config) { c.Passwords.Hasher = "md5" }, "PASSWORD_HASHER"},
+		{"oidc without client", func(c *Config) { c.OIDC.IssuerURL = "https://id.example.com" }, "OIDC_CLIENT_ID is required"},
+		{"log level", func(c *Config) { c.Log.Level = "verbose" }, "LOG_LEVEL"},
+		{"log format", func(c *Config) { c.Log.Format = "xml" }, "LOG_FORMAT"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := validConfig()
+			tt.change(&config)
+
+			err := config.Validate()
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate = %v, want no error", err)
+				}
+				return
+			}
+
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate = %v, want it to mention %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateReportsEveryProblem(t *testing.T) {
+	config := validConfig()
+	config.Server.PublicURL = ""
+	config.Database.Host = ""
+	config.Log.Format = "xml"
+
+	err := config.Validate()
+	if err == nil {
+		t.Fatal("Validate accepted an invalid config")
+	}
+
+	for _, want := range []string{"PUBLIC_URL", "DB_HOST", "LOG_FORMAT"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Validate = %v, want it to mention %s", err, want)
+		}
+	}
+}
+
+func validConfig() Config {
+	config := defaults()
+	config.Server.DevPort = 8080
+	config.Server.PublicURL = requiredEnv["PUBLIC_URL"]
+	config.Database.Host = requiredEnv["DB_HOST"]
+	config.Database.User = requiredEnv["DB_USER"]
+	config.Database.Name = requiredEnv["DB_NAME"]
+	config.JWT.Secret = requiredEnv["JWT_SECRET"]
+	config.JWT.SigningKeySecret = requiredEnv["SIGNING_KEY_SECRET"]
+	config.TwoFactor.EncryptionSecret = requiredEnv["TOTP_ENCRYPTION_SECRET"]
+	return config
+}
@@ -0,0 +1,11 @@
+package audit
+
+// actions recorded in the admin audit log
+const (
+	ROLE_CHANGED          = "user.role_changed"
+	USER_DISABLED         = "user.disabled"
+	USER_ENABLED          = "user.enabled"
+	USER_DELETED          = "user.deleted"
+	PASSWORD_RESET_FORCED = "user.password_reset_forced"
+	SESSIONS_REVOKED      = "user.sessions_revoked"
+)
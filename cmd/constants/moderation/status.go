@@ -0,0 +1,10 @@
+package moderation
+
+const (
+	PENDING  = "pending"
+	APPROVED = "approved"
+	REJECTED = "rejected"
+	SPAM     = "spam"
+)
+
+var STATUSES = []string{PENDING, APPROVED, REJECTED, SPAM}
@@ -0,0 +1,33 @@
+package permissions
+
+// permissions that can be granted to a role
+const (
+	// write posts and edit or delete your own
+	POST_CREATE = "post.create"
+	// publish and schedule posts you can edit
+	POST_PUBLISH    = "post.publish"
+	POST_EDIT_ANY   = "post.edit.any"
+	POST_DELETE_ANY = "post.delete.any"
+	// comment and edit or delete your own comments
+	COMMENT_CREATE = "comment.create"
+	// moderate comments on your own posts
+	COMMENT_MODERATE = "comment.moderate"
+	// moderate and delete any comment and change the global settings
+	COMMENT_MODERATE_ANY = "comment.moderate.any"
+	TAXONOMY_MANAGE      = "taxonomy.manage"
+	USER_MANAGE          = "user.manage"
+	ROLE_MANAGE          = "role.manage"
+)
+
+var PERMISSIONS = []string{
+	POST_CREATE,
+	POST_PUBLISH,
+	POST_EDIT_ANY,
+	POST_DELETE_ANY,
+	COMMENT_CREATE,
+	COMMENT_MODERATE,
+	COMMENT_MODERATE_ANY,
+	TAXONOMY_MANAGE,
+	USER_MANAGE,
+	ROLE_MANAGE,
+}
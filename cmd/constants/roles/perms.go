@@ -1,4 +0,0 @@
-package roles
-
-var BLOGGER_PERMS = []int{ADMIN, BLOGGER}
-var ADMIN_PERMS = []int{ADMIN}
\ No newline at end of file
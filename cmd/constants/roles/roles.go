@@ -1,7 +1,7 @@
 package roles
+
+// ids of the built-in roles, every other role lives only in the database
 const (
 	BLOGGER int = iota
 	ADMIN
 )
-
-var ROLES = []int{BLOGGER, ADMIN}
\ No newline at end of file
@@ -0,0 +1,13 @@
+package scopes
+
+// scopes a personal access token can be granted
+const (
+	POSTS_WRITE       = "posts:write"
+	COMMENTS_WRITE    = "comments:write"
+	COMMENTS_MODERATE = "comments:moderate"
+	CATEGORIES_ADMIN  = "categories:admin"
+	TAGS_ADMIN        = "tags:admin"
+	USERS_ADMIN       = "users:admin"
+)
+
+var SCOPES = []string{POSTS_WRITE, COMMENTS_WRITE, COMMENTS_MODERATE, CATEGORIES_ADMIN, TAGS_ADMIN, USERS_ADMIN}
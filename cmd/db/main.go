@@ -1,28 +1,32 @@
 package db
 
 import (
-	"fmt"
 	"log"
-	"os"
+	"log/slog"
+	"time"
 
+	"github.com/noctispine/blog/cmd/config"
+	"github.com/noctispine/blog/pkg/logging"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
 )
 
-func GetDatabase() *gorm.DB {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable",
-	 os.Getenv("DB_HOST"), os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_NAME"), os.Getenv("DB_PORT"))
-
+func GetDatabase(cfg config.Database, logger *slog.Logger) *gorm.DB {
 	db, err := gorm.Open(postgres.New(postgres.Config{
-		DSN: dsn,
+		DSN: cfg.DSN(),
 		PreferSimpleProtocol: true, // disables implicit prepared statement usage
-	}), &gorm.Config{})
+	}), &gorm.Config{
+		// table names match the migrations, e.g. user_account, post_category
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+		Logger: logging.NewGormLogger(logger, time.Duration(cfg.SlowQueryMilliseconds)*time.Millisecond),
+	})
 
 	if err != nil {
 		log.Fatalln("wrong database url")
 	}
 
-	fmt.Println("connected to database")
+	logger.Info("connected to database")
 	return db
 }
 
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS tag;
+DROP TABLE IF EXISTS post_category;
+DROP TABLE IF EXISTS category;
+DROP TABLE IF EXISTS post_meta;
+DROP TABLE IF EXISTS post;
+DROP TABLE IF EXISTS user_account;
@@ -0,0 +1,60 @@
+CREATE TABLE user_account (
+	id BIGSERIAL PRIMARY KEY,
+	first_name VARCHAR(50) NOT NULL,
+	last_name VARCHAR(50) NOT NULL,
+	email VARCHAR(255) NOT NULL UNIQUE,
+	password_hash TEXT NOT NULL,
+	registered_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_login_at TIMESTAMPTZ,
+	intro_desc TEXT NOT NULL DEFAULT '',
+	role INTEGER NOT NULL DEFAULT 0,
+	profile_desc TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE post (
+	id BIGSERIAL PRIMARY KEY,
+	parent_id BIGINT REFERENCES post (id) ON DELETE SET NULL,
+	user_id BIGINT NOT NULL REFERENCES user_account (id) ON DELETE CASCADE,
+	title VARCHAR(75) NOT NULL,
+	slug VARCHAR(100) NOT NULL UNIQUE,
+	summary TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	published_at TIMESTAMPTZ,
+	content TEXT NOT NULL DEFAULT '',
+	is_published BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX post_user_id_idx ON post (user_id);
+
+CREATE TABLE post_meta (
+	id BIGSERIAL PRIMARY KEY,
+	post_id BIGINT NOT NULL REFERENCES post (id) ON DELETE CASCADE,
+	key VARCHAR(50) NOT NULL,
+	content TEXT
+);
+
+CREATE TABLE category (
+	id BIGSERIAL PRIMARY KEY,
+	parent_id BIGINT REFERENCES category (id) ON DELETE SET NULL,
+	title VARCHAR(75) NOT NULL,
+	slug VARCHAR(100) NOT NULL UNIQUE,
+	content TEXT NOT NULL DEFAULT ''
+);
+
+CREATE INDEX category_parent_id_idx ON category (parent_id);
+
+CREATE TABLE post_category (
+	post_id BIGINT NOT NULL REFERENCES post (id) ON DELETE CASCADE,
+	category_id BIGINT NOT NULL REFERENCES category (id) ON DELETE CASCADE,
+	PRIMARY KEY (post_id, category_id)
+);
+
+CREATE INDEX post_category_category_id_idx ON post_category (category_id);
+
+CREATE TABLE tag (
+	id BIGSERIAL PRIMARY KEY,
+	title VARCHAR(75) NOT NULL,
+	slug VARCHAR(100) NOT NULL UNIQUE,
+	content TEXT NOT NULL DEFAULT ''
+);
@@ -0,0 +1,14 @@
+CREATE TABLE comment (
+	id BIGSERIAL PRIMARY KEY,
+	parent_id BIGINT REFERENCES comment (id) ON DELETE CASCADE,
+	post_id BIGINT NOT NULL REFERENCES post (id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES user_account (id) ON DELETE CASCADE,
+	title VARCHAR(100) NOT NULL DEFAULT '',
+	content TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	published_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX comment_post_id_idx ON comment (post_id);
+CREATE INDEX comment_parent_id_idx ON comment (parent_id);
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS moderation_setting;
+
+DROP INDEX IF EXISTS comment_status_idx;
+ALTER TABLE comment DROP COLUMN IF EXISTS moderated_at;
+ALTER TABLE comment DROP COLUMN IF EXISTS moderated_by;
+ALTER TABLE comment DROP COLUMN IF EXISTS status;
@@ -0,0 +1,18 @@
+-- comments written before moderation existed were already public
+ALTER TABLE comment ADD COLUMN status VARCHAR(16) NOT NULL DEFAULT 'approved'
+	CHECK (status IN ('pending', 'approved', 'rejected', 'spam'));
+ALTER TABLE comment ALTER COLUMN status SET DEFAULT 'pending';
+ALTER TABLE comment ADD COLUMN moderated_by BIGINT REFERENCES user_account (id) ON DELETE SET NULL;
+ALTER TABLE comment ADD COLUMN moderated_at TIMESTAMPTZ;
+
+CREATE INDEX comment_status_idx ON comment (status);
+
+-- a row with a NULL post_id holds the global settings, other rows override
+-- them for a single post
+CREATE TABLE moderation_setting (
+	id BIGSERIAL PRIMARY KEY,
+	post_id BIGINT REFERENCES post (id) ON DELETE CASCADE,
+	auto_approve_known BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE UNIQUE INDEX moderation_setting_post_id_idx ON moderation_setting (COALESCE(post_id, 0));
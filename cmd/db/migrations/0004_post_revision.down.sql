@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS post_revision;
+DROP FUNCTION IF EXISTS post_revision_immutable();
@@ -0,0 +1,35 @@
+CREATE TABLE post_revision (
+	id BIGSERIAL PRIMARY KEY,
+	post_id BIGINT NOT NULL REFERENCES post (id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES user_account (id) ON DELETE CASCADE,
+	title VARCHAR(75) NOT NULL,
+	summary TEXT NOT NULL DEFAULT '',
+	content TEXT NOT NULL DEFAULT '',
+	restored_from BIGINT REFERENCES post_revision (id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX post_revision_post_id_idx ON post_revision (post_id, id);
+
+-- revisions are history, only deleting the post (or a restored-from
+-- revision going away) may touch them
+CREATE FUNCTION post_revision_immutable() RETURNS trigger AS $$
+BEGIN
+	IF NEW.post_id IS DISTINCT FROM OLD.post_id
+		OR NEW.user_id IS DISTINCT FROM OLD.user_id
+		OR NEW.title IS DISTINCT FROM OLD.title
+		OR NEW.summary IS DISTINCT FROM OLD.summary
+		OR NEW.content IS DISTINCT FROM OLD.content
+		OR NEW.created_at IS DISTINCT FROM OLD.created_at THEN
+		RAISE EXCEPTION 'post revisions are immutable';
+	END IF;
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER post_revision_immutable BEFORE UPDATE ON post_revision
+	FOR EACH ROW EXECUTE FUNCTION post_revision_immutable();
+
+-- existing posts start their history from their current content
+INSERT INTO post_revision (post_id, user_id, title, summary, content, created_at)
+	SELECT id, user_id, title, summary, content, updated_at FROM post;
@@ -0,0 +1,6 @@
+-- an unpublished post with a published_at is scheduled, so clear stray
+-- timestamps before the publisher starts looking at them
+UPDATE post SET published_at = NULL WHERE is_published = false;
+UPDATE post SET published_at = created_at WHERE is_published = true AND published_at IS NULL;
+
+CREATE INDEX post_scheduled_idx ON post (published_at) WHERE is_published = false AND published_at IS NOT NULL;
@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS post_search_vector_idx;
+DROP TRIGGER IF EXISTS post_search_vector_update ON post;
+DROP FUNCTION IF EXISTS post_search_vector_update();
+ALTER TABLE post DROP COLUMN IF EXISTS search_vector;
+DROP FUNCTION IF EXISTS post_search_vector(REGCONFIG, TEXT, TEXT, TEXT);
+DROP TABLE IF EXISTS search_config;
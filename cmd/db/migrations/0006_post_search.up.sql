@@ -0,0 +1,30 @@
+-- single row holding the text search configuration the post index was built
+-- with, the server keeps it in sync with SEARCH_LANGUAGE on startup
+CREATE TABLE search_config (
+	id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+	language REGCONFIG NOT NULL DEFAULT 'english'
+);
+
+INSERT INTO search_config DEFAULT VALUES;
+
+CREATE FUNCTION post_search_vector(lang REGCONFIG, title TEXT, summary TEXT, content TEXT) RETURNS TSVECTOR AS $$
+	SELECT setweight(to_tsvector(lang, coalesce(title, '')), 'A')
+		|| setweight(to_tsvector(lang, coalesce(summary, '')), 'B')
+		|| setweight(to_tsvector(lang, coalesce(content, '')), 'C');
+$$ LANGUAGE sql IMMUTABLE;
+
+ALTER TABLE post ADD COLUMN search_vector TSVECTOR;
+
+CREATE FUNCTION post_search_vector_update() RETURNS trigger AS $$
+BEGIN
+	NEW.search_vector := post_search_vector((SELECT language FROM search_config), NEW.title, NEW.summary, NEW.content);
+	RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER post_search_vector_update BEFORE INSERT OR UPDATE OF title, summary, content ON post
+	FOR EACH ROW EXECUTE FUNCTION post_search_vector_update();
+
+UPDATE post SET search_vector = post_search_vector('english', title, summary, content);
+
+CREATE INDEX post_search_vector_idx ON post USING GIN (search_vector);
@@ -0,0 +1,9 @@
+-- the tag feeds select posts through this table, the tagging endpoints
+-- and models.PostTag build on it
+CREATE TABLE post_tag (
+	post_id BIGINT NOT NULL REFERENCES post (id) ON DELETE CASCADE,
+	tag_id BIGINT NOT NULL REFERENCES tag (id) ON DELETE CASCADE,
+	PRIMARY KEY (post_id, tag_id)
+);
+
+CREATE INDEX post_tag_tag_id_idx ON post_tag (tag_id);
@@ -0,0 +1,16 @@
+-- opaque refresh tokens, only their sha256 is stored. Every refresh marks
+-- the presented token used and issues a new one in the same family, using a
+-- token twice revokes the whole family.
+CREATE TABLE refresh_token (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES user_account (id) ON DELETE CASCADE,
+	family_id VARCHAR(32) NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX refresh_token_user_id_idx ON refresh_token (user_id);
+CREATE INDEX refresh_token_family_id_idx ON refresh_token (family_id);
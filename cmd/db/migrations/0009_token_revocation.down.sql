@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS revoked_token;
+ALTER TABLE user_account DROP COLUMN IF EXISTS tokens_valid_after;
@@ -0,0 +1,13 @@
+-- access tokens issued before this time are rejected, set on password
+-- changes, account disables and when an admin revokes a user's sessions
+ALTER TABLE user_account ADD COLUMN tokens_valid_after TIMESTAMPTZ;
+
+-- individually revoked access tokens, kept until they would have expired
+CREATE TABLE revoked_token (
+	jti VARCHAR(64) PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES user_account (id) ON DELETE CASCADE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX revoked_token_created_at_idx ON revoked_token (created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE user_account DROP COLUMN IF EXISTS verification_sent_at;
+ALTER TABLE user_account DROP COLUMN IF EXISTS verified_at;
@@ -0,0 +1,5 @@
+ALTER TABLE user_account ADD COLUMN verified_at TIMESTAMPTZ;
+ALTER TABLE user_account ADD COLUMN verification_sent_at TIMESTAMPTZ;
+
+-- accounts that existed before verification was required stay usable
+UPDATE user_account SET verified_at = registered_at;
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS two_factor_challenge;
+DROP TABLE IF EXISTS recovery_code;
+ALTER TABLE user_account DROP COLUMN IF EXISTS totp_last_counter;
+ALTER TABLE user_account DROP COLUMN IF EXISTS totp_enabled_at;
+ALTER TABLE user_account DROP COLUMN IF EXISTS totp_secret;
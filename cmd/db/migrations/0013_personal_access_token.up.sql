@@ -0,0 +1,13 @@
+CREATE TABLE personal_access_token (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES user_account (id) ON DELETE CASCADE,
+	name VARCHAR(100) NOT NULL,
+	token_hash VARCHAR(64) NOT NULL UNIQUE,
+	scopes VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	last_used_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX personal_access_token_user_id_idx ON personal_access_token (user_id);
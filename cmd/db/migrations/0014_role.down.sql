@@ -0,0 +1,3 @@
+ALTER TABLE user_account DROP CONSTRAINT IF EXISTS user_account_role_fkey;
+DROP TABLE IF EXISTS role_permission;
+DROP TABLE IF EXISTS role;
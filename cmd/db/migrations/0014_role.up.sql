@@ -0,0 +1,38 @@
+CREATE TABLE role (
+	id SERIAL PRIMARY KEY,
+	name VARCHAR(50) NOT NULL UNIQUE,
+	description VARCHAR(255) NOT NULL DEFAULT '',
+	built_in BOOLEAN NOT NULL DEFAULT false,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- the ids match the integers user_account.role already holds
+INSERT INTO role (id, name, description, built_in) VALUES
+	(0, 'blogger', 'Writes and publishes their own posts', true),
+	(1, 'admin', 'Full access', true);
+
+SELECT setval(pg_get_serial_sequence('role', 'id'), 1);
+
+CREATE TABLE role_permission (
+	role_id INTEGER NOT NULL REFERENCES role (id) ON DELETE CASCADE,
+	permission VARCHAR(64) NOT NULL,
+	PRIMARY KEY (role_id, permission)
+);
+
+INSERT INTO role_permission (role_id, permission) VALUES
+	(0, 'post.create'),
+	(0, 'post.publish'),
+	(0, 'comment.create'),
+	(0, 'comment.moderate'),
+	(1, 'post.create'),
+	(1, 'post.publish'),
+	(1, 'post.edit.any'),
+	(1, 'post.delete.any'),
+	(1, 'comment.create'),
+	(1, 'comment.moderate'),
+	(1, 'comment.moderate.any'),
+	(1, 'taxonomy.manage'),
+	(1, 'user.manage'),
+	(1, 'role.manage');
+
+ALTER TABLE user_account ADD CONSTRAINT user_account_role_fkey FOREIGN KEY (role) REFERENCES role (id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS admin_audit_log;
+ALTER TABLE user_account DROP COLUMN IF EXISTS password_reset_required;
+ALTER TABLE user_account DROP COLUMN IF EXISTS disabled_reason;
+ALTER TABLE user_account DROP COLUMN IF EXISTS disabled_at;
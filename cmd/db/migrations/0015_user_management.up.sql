@@ -0,0 +1,17 @@
+ALTER TABLE user_account ADD COLUMN disabled_at TIMESTAMPTZ;
+ALTER TABLE user_account ADD COLUMN disabled_reason VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE user_account ADD COLUMN password_reset_required BOOLEAN NOT NULL DEFAULT false;
+
+-- target_email keeps entries readable after the user is deleted
+CREATE TABLE admin_audit_log (
+	id BIGSERIAL PRIMARY KEY,
+	actor_id BIGINT REFERENCES user_account (id) ON DELETE SET NULL,
+	target_user_id BIGINT REFERENCES user_account (id) ON DELETE SET NULL,
+	target_email VARCHAR(255) NOT NULL,
+	action VARCHAR(50) NOT NULL,
+	details TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX admin_audit_log_actor_id_idx ON admin_audit_log (actor_id);
+CREATE INDEX admin_audit_log_target_user_id_idx ON admin_audit_log (target_user_id);
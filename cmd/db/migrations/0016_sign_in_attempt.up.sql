@@ -0,0 +1,6 @@
+CREATE TABLE sign_in_attempt (
+	key VARCHAR(320) PRIMARY KEY,
+	failures INTEGER NOT NULL DEFAULT 0,
+	last_failure_at TIMESTAMPTZ NOT NULL,
+	locked_until TIMESTAMPTZ
+);
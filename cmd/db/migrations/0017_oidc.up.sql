@@ -0,0 +1,22 @@
+CREATE TABLE external_identity (
+	id BIGSERIAL PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES user_account (id) ON DELETE CASCADE,
+	issuer VARCHAR(255) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	email VARCHAR(255) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	last_login_at TIMESTAMPTZ,
+	UNIQUE (issuer, subject)
+);
+
+CREATE INDEX external_identity_user_id_idx ON external_identity (user_id);
+
+CREATE TABLE oidc_login_state (
+	id BIGSERIAL PRIMARY KEY,
+	state_hash VARCHAR(64) NOT NULL UNIQUE,
+	nonce VARCHAR(64) NOT NULL,
+	code_verifier VARCHAR(128) NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at TIMESTAMPTZ
+);
@@ -0,0 +1,8 @@
+CREATE TABLE signing_key (
+	kid VARCHAR(32) PRIMARY KEY,
+	algorithm VARCHAR(16) NOT NULL,
+	private_key TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	activates_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ
+);
@@ -0,0 +1,5 @@
+-- encrypted secrets don't fit and can't be decrypted here, those users
+-- enroll again
+DELETE FROM recovery_code WHERE user_id IN (SELECT id FROM user_account WHERE totp_secret LIKE 'aes256gcm:%');
+UPDATE user_account SET totp_secret = NULL, totp_enabled_at = NULL, totp_last_counter = 0 WHERE totp_secret LIKE 'aes256gcm:%';
+ALTER TABLE user_account ALTER COLUMN totp_secret TYPE VARCHAR(64);
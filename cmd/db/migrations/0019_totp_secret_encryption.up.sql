@@ -0,0 +1,2 @@
+-- encrypted secrets are longer than the base32 ones
+ALTER TABLE user_account ALTER COLUMN totp_secret TYPE TEXT;
@@ -0,0 +1,37 @@
+package db
+
+import (
+	"fmt"
+	"log/slog"
+
+	"gorm.io/gorm"
+)
+
+// SyncSearchLanguage makes sure the post search index is built with the given
+// text search configuration (english, simple, german...). When the language
+// changed since the last start, every post is re-indexed.
+func SyncSearchLanguage(db *gorm.DB, logger *slog.Logger, language string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var current string
+		if err := tx.Raw("SELECT language::text FROM search_config FOR UPDATE").Scan(&current).Error; err != nil {
+			return err
+		}
+
+		var requested string
+		if err := tx.Raw("SELECT ?::regconfig::text", language).Scan(&requested).Error; err != nil {
+			return fmt.Errorf("unknown search language %q: %w", language, err)
+		}
+
+		if current == requested {
+			return nil
+		}
+
+		logger.Info("re-indexing posts", slog.String("from", current), slog.String("to", requested))
+
+		if err := tx.Exec("UPDATE search_config SET language = ?::regconfig", requested).Error; err != nil {
+			return err
+		}
+
+		return tx.Exec("UPDATE post SET search_vector = post_search_vector(?::regconfig, title, summary, content)", requested).Error
+	})
+}
@@ -3,28 +3,54 @@ package handlers
 import (
 	"context"
 	"errors"
+	"log/slog"
+	"math"
 	"net/http"
-	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/noctispine/blog/cmd/config"
+	"github.com/noctispine/blog/cmd/constants/audit"
 	"github.com/noctispine/blog/cmd/models"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/encryption"
+	"github.com/noctispine/blog/pkg/lockout"
+	"github.com/noctispine/blog/pkg/mail"
+	"github.com/noctispine/blog/pkg/passwords"
+	"github.com/noctispine/blog/pkg/revocation"
+	"github.com/noctispine/blog/pkg/signing"
+	"github.com/noctispine/blog/pkg/wrappers"
 	"gorm.io/gorm"
 )
 
 type AuthHandler struct {
 	ctx context.Context
 	db *gorm.DB
+	logger *slog.Logger
+	revocations *revocation.Store
+	mailer mail.Mailer
+	baseURL string
+	accountLimiter lockout.Limiter
+	ipLimiter lockout.Limiter
+	hasher passwords.Hasher
+	keys *signing.KeyRing
+	// decrypts the TOTP secrets checked by SignInTwoFactor
+	totpSecrets *encryption.Key
+	tokens config.JWT
 }
 
+// Claims of an access token. RegisteredClaims.ID is the token's jti, used
+// to revoke it, and IssuedAt is checked against the user's revocation time.
+// Permissions are the role's permissions when the token was issued.
 type Claims struct {
 	Email string `json:"email"`
 	UserID int64 
 	jwt.RegisteredClaims
 	Role int
+	Permissions []string `json:"permissions"`
 }
 
 type JWTOutput struct {
@@ -32,21 +58,56 @@ type JWTOutput struct {
 	Expires time.Time `json:"expires"`
 }
 
-func NewAuthHandler(ctx context.Context, db *gorm.DB) *AuthHandler{
+func NewAuthHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, revocations *revocation.Store, mailer mail.Mailer, baseURL string, accountLimiter lockout.Limiter, ipLimiter lockout.Limiter, hasher passwords.Hasher, keys *signing.KeyRing, totpSecrets *encryption.Key, tokens config.JWT) *AuthHandler{
 	return &AuthHandler{
 		ctx,
 		db,
+		logger,
+		revocations,
+		mailer,
+		strings.TrimSuffix(baseURL, "/"),
+		accountLimiter,
+		ipLimiter,
+		hasher,
+		keys,
+		totpSecrets,
+		tokens,
 	}
 }
 
-func hashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	return string(bytes), err
-}
+// checkPassword verifies the user's password. A hash made with another
+// algorithm or outdated parameters is replaced with a fresh one, which is
+// only possible now that the plain password is at hand.
+func (h *AuthHandler) checkPassword(c *gin.Context, user models.UserAccount, password string) bool {
+	// accounts created through an identity provider have no password
+	if user.PasswordHash == "" {
+		return false
+	}
+
+	ok, err := h.hasher.Verify(password, user.PasswordHash)
+	if err != nil {
+		logError(c, h.logger, err)
+		return false
+	}
+
+	if !ok || !h.hasher.NeedsRehash(user.PasswordHash) {
+		return ok
+	}
 
-func checkPasswordHash(password, hashedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+	rehashed, err := h.hasher.Hash(password)
+	if err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "rehashing password failed", slog.Any("error", err))
+		return true
+	}
+
+	// the old hash in the condition keeps a concurrent password change
+	if err := h.db.Model(&models.UserAccount{}).
+		Where("id = ? AND password_hash = ?", user.ID, user.PasswordHash).
+		Update("password_hash", rehashed).Error; err != nil {
+		h.logger.ErrorContext(c.Request.Context(), "storing rehashed password failed", slog.Any("error", err))
+	}
+
+	return true
 }
 
 func (h *AuthHandler) SignInHandler(c *gin.Context) {
@@ -67,10 +128,19 @@ func (h *AuthHandler) SignInHandler(c *gin.Context) {
 		return
 	}
 
+	accountKey := "account:" + strings.ToLower(user.Email)
+	ipKey := "ip:" + c.ClientIP()
+
+	if !h.checkLockout(c, accountKey, ipKey) {
+		return
+	}
+
 	if err := h.db.Where("email = ?", user.Email).First(&dbUser).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "user not found"})
+			if h.recordFailure(c, accountKey, ipKey) {
+				c.JSON(http.StatusUnauthorized, gin.H{
+					"error": "user not found"})
+			}
 			return
 		}
 		
@@ -79,31 +149,140 @@ func (h *AuthHandler) SignInHandler(c *gin.Context) {
 		return
 	}
 
-	if !checkPasswordHash(user.Password, dbUser.PasswordHash) {
-		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Wrong Credentials"})
+	if !h.checkPassword(c, dbUser, user.Password) {
+		if h.recordFailure(c, accountKey, ipKey) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Wrong Credentials"})
+		}
+		return
+	}
+
+	if dbUser.DisabledAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Account is disabled"})
+		return
+	}
+
+	if dbUser.PasswordResetRequired {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Password has to be reset, check your email for the reset link"})
 		return
 	}
 
-	expireInMinutes, err := strconv.Atoi(os.Getenv("JWT_EXPIRE_MINUTES"))
+	if dbUser.TOTPEnabledAt != nil {
+		challengeToken, challengeExpires, err := createTwoFactorChallenge(h.db, dbUser.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"twoFactorRequired": true,
+			"challengeToken": challengeToken,
+			"challengeExpires": challengeExpires})
+		return
+	}
+
+	if !h.resetLockout(c, accountKey, ipKey) {
+		return
+	}
+
+	h.completeSignIn(c, dbUser)
+}
+
+// checkLockout answers 429 if the account or the client is locked out.
+func (h *AuthHandler) checkLockout(c *gin.Context, accountKey string, ipKey string) bool {
+	accountWait, err := h.accountLimiter.Check(c.Request.Context(), accountKey)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error()})
-		return
+		return false
 	}
 
-	expirationTime := time.Now().Add(time.Duration(expireInMinutes) * time.Minute)
-	claims := &Claims{
-		Email: user.Email,
-		UserID: dbUser.ID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(expirationTime),
-		},
-		Role: dbUser.Role,
+	ipWait, err := h.ipLimiter.Check(c.Request.Context(), ipKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return false
+	}
+
+	return abortLockedOut(c, accountWait, ipWait)
+}
+
+// recordFailure counts a failed sign in against the account and the client.
+// It answers 429 and returns false if this failure started a lockout.
+func (h *AuthHandler) recordFailure(c *gin.Context, accountKey string, ipKey string) bool {
+	accountWait, err := h.accountLimiter.Fail(c.Request.Context(), accountKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return false
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	ipWait, err := h.ipLimiter.Fail(c.Request.Context(), ipKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return false
+	}
+
+	return abortLockedOut(c, accountWait, ipWait)
+}
+
+// resetLockout forgets the failures of the account and the client once
+// signing in fully succeeded, the second factor included.
+func (h *AuthHandler) resetLockout(c *gin.Context, accountKey string, ipKey string) bool {
+	if err := h.accountLimiter.Reset(c.Request.Context(), accountKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return false
+	}
+
+	if err := h.ipLimiter.Reset(c.Request.Context(), ipKey); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return false
+	}
+
+	return true
+}
+
+func abortLockedOut(c *gin.Context, waits ...time.Duration) bool {
+	return abortTooManyRequests(c, "Too many failed sign in attempts, try again later", waits...)
+}
+
+// abortTooManyRequests answers 429 with a Retry-After of the longest wait
+// and returns false, or returns true if none of the waits is left.
+func abortTooManyRequests(c *gin.Context, message string, waits ...time.Duration) bool {
+	var wait time.Duration
+	for _, w := range waits {
+		if w > wait {
+			wait = w
+		}
+	}
+
+	if wait <= 0 {
+		return true
+	}
+
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": message})
+	return false
+}
+
+// completeSignIn issues the tokens of a new session once every check of
+// signing in passed.
+func (h *AuthHandler) completeSignIn(c *gin.Context, dbUser models.UserAccount) {
+	tokenString, expires, err := h.issueAccessToken(h.db, dbUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	refreshToken, refreshExpires, err := issueRefreshToken(h.db, dbUser.ID, "", h.refreshTokenLifetime())
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error()})
@@ -112,7 +291,7 @@ func (h *AuthHandler) SignInHandler(c *gin.Context) {
 
 	dbUser.LastLoginAt = time.Now()
 
-	if err := h.db.Save(&dbUser).Error; err != nil {
+	if err := h.db.Model(&models.UserAccount{}).Where("id = ?", dbUser.ID).Update("last_login_at", dbUser.LastLoginAt).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error()})	
 		return
@@ -127,55 +306,298 @@ func (h *AuthHandler) SignInHandler(c *gin.Context) {
 			IntroDesc: dbUser.IntroDesc,
 			ProfileDesc: dbUser.ProfileDesc,
 		},
-		"token": tokenString})
+		"token": tokenString,
+		"expires": expires,
+		"refreshToken": refreshToken,
+		"refreshExpires": refreshExpires})
 }
 
-func (h *AuthHandler) RefreshHandler(c *gin.Context) {
-	tokenValue := c.GetHeader("Authorization")
+// issueAccessToken signs a short lived JWT for the user.
+func (h *AuthHandler) issueAccessToken(db *gorm.DB, user models.UserAccount) (string, time.Time, error) {
+	granted, err := RolePermissions(db, user.Role)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	expirationTime := now.Add(time.Duration(h.tokens.ExpireMinutes) * time.Minute)
+	claims := &Claims{
+		Email: user.Email,
+		UserID: user.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID: jti,
+			IssuedAt: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(expirationTime),
+		},
+		Role: user.Role,
+		Permissions: granted,
+	}
+
+	tokenString, err := h.keys.Sign(claims)
 
+	return tokenString, expirationTime, err
+}
+
+const bearerScheme = "Bearer "
+
+// BearerToken returns the token of an Authorization header using the Bearer
+// scheme. ok is false for any other header.
+func BearerToken(header string) (token string, ok bool) {
+	if len(header) < len(bearerScheme) || !strings.EqualFold(header[:len(bearerScheme)], bearerScheme) {
+		return "", false
+	}
+
+	token = strings.TrimSpace(header[len(bearerScheme):])
+	return token, token != ""
+}
+
+// ParseAccessToken verifies the signature and expiry of an access token.
+// Revocation is checked separately.
+func ParseAccessToken(keys *signing.KeyRing, tokenValue string) (*Claims, error) {
 	claims := &Claims{}
-	tkn, err := jwt.ParseWithClaims(tokenValue, claims, 
-		func(token *jwt.Token) (interface{}, error){
-			return []byte(os.Getenv("JWT_SECRET")), nil
-	})
+
+	tkn, err := jwt.ParseWithClaims(tokenValue, claims, keys.Keyfunc)
 
 	if err != nil {
-		if errors.Is(err, jwt.ErrTokenExpired) {
+		return nil, err
+	}
+
+	if tkn == nil || !tkn.Valid {
+		return nil, errors.New("Invalid token")
+	}
+
+	return claims, nil
+}
+
+func (h *AuthHandler) refreshTokenLifetime() time.Duration {
+	return time.Duration(h.tokens.RefreshExpireHours) * time.Hour
+}
+
+// JWKS publishes the public keys access tokens are signed with, so other
+// services can verify them.
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.keys.JWKS())
+}
+
+// RefreshHandler exchanges a refresh token for a new access token and a new
+// refresh token. The presented token can't be used again, and presenting an
+// already used token means it leaked, so its whole family is revoked and
+// every session descending from that sign in has to sign in again.
+func (h *AuthHandler) RefreshHandler(c *gin.Context) {
+	var request models.RefreshTokenRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(request); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	var stored models.RefreshToken
+	if err := h.db.Where("token_hash = ?", hashToken(request.RefreshToken)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusUnauthorized, gin.H{
-				"error": "Token is expired"})
+				"error": "Invalid token"})
 			return
-		} 
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
 
+	if stored.RevokedAt != nil {
 		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Token is revoked"})
+		return
+	}
+
+	if time.Now().After(stored.ExpiresAt) {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Token is expired"})
+		return
+	}
+
+	var dbUser models.UserAccount
+	var tokenString, refreshToken string
+	var expires, refreshExpires time.Time
+	reused := false
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.RefreshToken{}).
+			Where("id = ? AND used_at IS NULL AND revoked_at IS NULL", stored.ID).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			reused = true
+			return nil
+		}
+
+		if err := tx.Where("id = ?", stored.UserID).First(&dbUser).Error; err != nil {
+			return err
+		}
+
+		var err error
+		if tokenString, expires, err = h.issueAccessToken(tx, dbUser); err != nil {
+			return err
+		}
+
+		refreshToken, refreshExpires, err = issueRefreshToken(tx, dbUser.ID, stored.FamilyID, h.refreshTokenLifetime())
+		return err
+	})
+
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error()})
 		return
 	}
 
-	if tkn == nil || !tkn.Valid {
+	if reused {
+		if err := revokeRefreshTokenFamily(h.db, stored.FamilyID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": err.Error()})
+			return
+		}
+
 		c.JSON(http.StatusUnauthorized, gin.H{
-			"error": "Invalid token"})
+			"error": "Token was already used, all sessions of this sign in are revoked"})
 		return
 	}
 
-	if  time.Until(claims.ExpiresAt.Time).Minutes()  > 5 {
+	c.JSON(http.StatusOK, gin.H{
+		"token": tokenString,
+		"expires": expires,
+		"refreshToken": refreshToken,
+		"refreshExpires": refreshExpires})
+}
+
+// Logout revokes the session the given refresh token belongs to and, when
+// the request carries one, the access token itself.
+func (h *AuthHandler) Logout(c *gin.Context) {
+	var request models.RefreshTokenRequest
+
+	if err := c.ShouldBindJSON(&request); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Token is not expired yet"})
+			"error": err.Error()})
 		return
 	}
 
-	expirationTime := time.Now().Add(10 * time.Minute)
-	claims.ExpiresAt = jwt.NewNumericDate(expirationTime)
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	if err := validate.Struct(request); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	var stored models.RefreshToken
+	if err := h.db.Where("token_hash = ?", hashToken(request.RefreshToken)).First(&stored).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.Status(http.StatusNoContent)
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := revokeRefreshTokenFamily(h.db, stored.FamilyID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if token, ok := BearerToken(c.GetHeader("Authorization")); ok {
+		if claims, err := ParseAccessToken(h.keys, token); err == nil && claims.ID != "" {
+			if err := h.revocations.RevokeToken(claims.ID, claims.UserID, claims.ExpiresAt.Time); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{
+					"error": err.Error()})
+				return
+			}
+		}
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// LogoutAll revokes every refresh and access token of the signed in user.
+func (h *AuthHandler) LogoutAll(c *gin.Context) {
+	if err := h.revokeSessions(c.GetInt64(keys.UserID)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
 
-	tokenString, err := token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+// RevokeUserSessions lets an admin sign a user out of every session.
+func (h *AuthHandler) RevokeUserSessions(c *gin.Context) {
+	userId, err := strconv.ParseInt(c.Params.ByName("id"), 10, 64)
 	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var target models.UserAccount
+	if err := h.db.Where("id = ?", userId).First(&target).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("user").Error()})
+			return
+		}
+
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"token": tokenString})
+	if err := h.revokeSessions(userId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := recordAudit(h.db, c, target, audit.SESSIONS_REVOKED, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *AuthHandler) revokeSessions(userId int64) error {
+	return revokeSessions(h.db, h.revocations, userId)
+}
+
+// revokeSessions signs the user out everywhere, revoking their refresh
+// tokens, personal access tokens and every access token issued so far.
+func revokeSessions(db *gorm.DB, revocations *revocation.Store, userId int64) error {
+	if err := revokeUserRefreshTokens(db, userId); err != nil {
+		return err
+	}
+
+	if err := revokeUserPersonalAccessTokens(db, userId); err != nil {
+		return err
+	}
+
+	return revocations.RevokeUser(userId)
 }
 
 func (h *AuthHandler) Register(c *gin.Context) {
@@ -195,7 +617,7 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	hashedPassword, err := hashPassword(user.Password)
+	hashedPassword, err := h.hasher.Hash(user.Password)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error()})
@@ -211,11 +633,14 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		ProfileDesc: user.ProfileDesc,
 		RegisteredAt: time.Now(),
 	}
+	newUser.VerificationSentAt = &newUser.RegisteredAt
 
 	if err := h.db.Create(&newUser).Error; err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{
 			"error": err.Error()})
 		return
 	}
+
+	sendVerification(c, h.logger, h.mailer, h.baseURL, h.tokens.Secret, newUser)
 	c.Status(http.StatusCreated)
 }
@@ -0,0 +1,29 @@
+package handlers
+
+import "testing"
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name string
+		header string
+		want string
+		wantOk bool
+	}{
+		{"jwt", "Bearer eyJhbGciOi.payload.sig", "eyJhbGciOi.payload.sig", true},
+		{"personal access token", "Bearer " + PersonalAccessTokenPrefix + "secret", PersonalAccessTokenPrefix + "secret", true},
+		{"scheme is case insensitive", "bearer token", "token", true},
+		{"missing scheme", "eyJhbGciOi.payload.sig", "", false},
+		{"other scheme", "Basic dXNlcjpwYXNz", "", false},
+		{"no token", "Bearer ", "", false},
+		{"empty", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := BearerToken(tt.header)
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("BearerToken(%q) = %q, %v, want %q, %v", tt.header, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
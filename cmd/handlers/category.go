@@ -2,7 +2,7 @@ package handlers
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -16,12 +16,14 @@ import (
 type CategoryHandler struct {
 	ctx context.Context
 	db *gorm.DB
+	logger *slog.Logger
 }
 
-func NewCategoryHandler(ctx context.Context, db *gorm.DB) *CategoryHandler {
+func NewCategoryHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger) *CategoryHandler {
 	return &CategoryHandler{
 		ctx,
 		db,
+		logger,
 	}
 }
 
@@ -35,7 +37,7 @@ func (h *CategoryHandler) GetAll(c *gin.Context) {
 	}
 
 	if result.Error != nil {
-		log.Println(result.Error.Error())
+		logError(c, h.logger, result.Error)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -67,7 +69,7 @@ func (h *CategoryHandler) Create(c *gin.Context) {
 			return
 		}
 
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -113,7 +115,7 @@ func (h *CategoryHandler) Update(c *gin.Context) {
 			return
 		}
 
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusBadGateway)
 		return
 	}
@@ -123,7 +125,7 @@ func (h *CategoryHandler) Update(c *gin.Context) {
 	}
 
 	if err := h.db.Model(&models.Category{}).Where("id = ?", updateCategory.ID).Omit("id").Updates(&updateCategory).Error; err != nil {
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/constants/moderation"
+	"github.com/noctispine/blog/cmd/constants/permissions"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/pagination"
+	"github.com/noctispine/blog/pkg/scopes"
+	"github.com/noctispine/blog/pkg/wrappers"
+	"gorm.io/gorm"
+)
+
+type CommentHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+}
+
+func NewCommentHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger) *CommentHandler {
+	return &CommentHandler{
+		ctx,
+		db,
+		logger,
+	}
+}
+
+// GetPage lists the approved comments of a post. With ?view=flat the whole
+// thread is returned depth-first with a depth on every comment and the page is
+// taken over that list. Otherwise (?view=tree, the default) the page is taken
+// over top-level comments and each one carries its nested replies.
+func (h *CommentHandler) GetPage(c *gin.Context) {
+	postId, err := strconv.ParseInt(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var post models.Post
+	if err := h.db.Select("id").Where("id = ? AND is_published = ?", postId, true).First(&post).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("post").Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	switch c.DefaultQuery("view", "tree") {
+	case "tree":
+		h.getTreePage(c, postId)
+	case "flat":
+		h.getFlatPage(c, postId)
+	default:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "view must be either tree or flat"})
+	}
+}
+
+func (h *CommentHandler) getTreePage(c *gin.Context, postId int64) {
+	var roots []*models.Comment
+	var pagination pagination.Pagination
+	pagination.Sort = "id asc"
+
+	query := h.db.Model(&models.Comment{}).Where("post_id = ? AND parent_id IS NULL AND status = ?", postId, moderation.APPROVED).Session(&gorm.Session{})
+	result := query.Scopes(scopes.Paginate(models.Comment{}, &pagination, query, c)).Find(&roots)
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	rootIds := make([]int64, len(roots))
+	for i, root := range roots {
+		rootIds[i] = root.ID
+	}
+
+	var replies []*models.Comment
+	if err := h.db.Raw(`WITH RECURSIVE thread AS (
+			SELECT * FROM comment WHERE parent_id IN @roots AND status = @approved
+			UNION ALL
+			SELECT comment.* FROM comment JOIN thread ON comment.parent_id = thread.id
+			WHERE comment.status = @approved
+		)
+		SELECT * FROM thread ORDER BY id`, sql.Named("roots", rootIds), sql.Named("approved", moderation.APPROVED)).Scan(&replies).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	pagination.Rows = buildCommentTree(roots, replies)
+	c.JSON(http.StatusOK, pagination)
+}
+
+func (h *CommentHandler) getFlatPage(c *gin.Context, postId int64) {
+	var comments []models.ThreadedComment
+	var pagination pagination.Pagination
+	pagination.Page = c.GetInt(keys.PageKey)
+	pagination.Limit = c.GetInt(keys.PageSizeKey)
+
+	// approved replies under an unapproved comment aren't shown, so the
+	// total is counted from the same thread instead of the comment table
+	thread := `WITH RECURSIVE thread AS (
+			SELECT comment.*, 0 AS depth, ARRAY[comment.id] AS path FROM comment
+			WHERE post_id = @post AND parent_id IS NULL AND status = @approved
+			UNION ALL
+			SELECT comment.*, thread.depth + 1, thread.path || comment.id FROM comment
+			JOIN thread ON comment.parent_id = thread.id
+			WHERE comment.status = @approved
+		)`
+
+	if err := h.db.Raw(thread+` SELECT COUNT(*) FROM thread`,
+		sql.Named("post", postId), sql.Named("approved", moderation.APPROVED)).Scan(&pagination.TotalRows).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	pagination.TotalPages = int(math.Ceil(float64(pagination.TotalRows) / float64(pagination.GetLimit())))
+
+	if err := h.db.Raw(thread+` SELECT * FROM thread ORDER BY path LIMIT @limit OFFSET @offset`,
+		sql.Named("post", postId), sql.Named("approved", moderation.APPROVED),
+		sql.Named("limit", pagination.GetLimit()), sql.Named("offset", pagination.GetOffset())).Scan(&comments).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if len(comments) == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	pagination.Rows = comments
+	c.JSON(http.StatusOK, pagination)
+}
+
+// buildCommentTree attaches every reply to its parent. Replies must be
+// ordered so that parents come before their children.
+func buildCommentTree(roots []*models.Comment, replies []*models.Comment) []*models.Comment {
+	byId := make(map[int64]*models.Comment, len(roots)+len(replies))
+	for _, root := range roots {
+		byId[root.ID] = root
+	}
+
+	for _, reply := range replies {
+		byId[reply.ID] = reply
+	}
+
+	for _, reply := range replies {
+		if parent, ok := byId[*reply.ParentID]; ok {
+			parent.Replies = append(parent.Replies, reply)
+		}
+	}
+
+	return roots
+}
+
+func (h *CommentHandler) Create(c *gin.Context) {
+	var comment models.Comment
+
+	postId, err := strconv.ParseInt(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := c.ShouldBindJSON(&comment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(comment); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	var post models.Post
+	if err := h.db.Select("id", "user_id", "is_published").Where("id = ?", postId).First(&post).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("post").Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if !post.IsPublished {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "comments are only allowed on published posts"})
+		return
+	}
+
+	if comment.ParentID != nil {
+		var parent models.Comment
+		if err := h.db.Select("id").Where("id = ? AND post_id = ? AND status = ?", *comment.ParentID, postId, moderation.APPROVED).First(&parent).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+					"error": wrappers.NewErrDoesNotExist("parent comment").Error()})
+				return
+			}
+
+			logError(c, h.logger, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	status, err := resolveCommentStatus(h.db, c, post)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	comment.ID = 0
+	comment.PostID = postId
+	comment.UserID = c.GetInt64(keys.UserID)
+	comment.CreatedAt = now
+	comment.UpdatedAt = now
+	comment.PublishedAt = now
+	comment.Status = status
+	comment.ModeratedBy = nil
+	comment.ModeratedAt = nil
+	comment.Replies = nil
+
+	if err := h.db.Create(&comment).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, comment)
+}
+
+func (h *CommentHandler) Update(c *gin.Context) {
+	var updateComment models.UpdateComment
+
+	commentId := c.Params.ByName("id")
+	if commentId == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := c.ShouldBindJSON(&updateComment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(updateComment); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	var comment models.Comment
+	if err := h.db.Where("id = ? AND user_id = ?", commentId, c.GetInt64(keys.UserID)).First(&comment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("comment").Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var post models.Post
+	if err := h.db.Select("id", "user_id", "is_published").Where("id = ?", comment.PostID).First(&post).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if !post.IsPublished {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "comments are only allowed on published posts"})
+		return
+	}
+
+	// an edit goes through moderation again, otherwise an approved comment
+	// could be rewritten into anything
+	status, err := resolveCommentStatus(h.db, c, post)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.db.Model(&models.Comment{}).Where("id = ?", comment.ID).Updates(map[string]interface{}{
+		"title": updateComment.Title,
+		"content": updateComment.Content,
+		"status": status,
+		"updated_at": time.Now(),
+	}).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// Delete removes a comment together with its replies. Authors can delete
+// their own comments, moderators can delete any comment. Unlike creating and
+// editing this works on unpublished posts too, taking a comment down is
+// always allowed.
+func (h *CommentHandler) Delete(c *gin.Context) {
+	commentId := c.Params.ByName("id")
+	if commentId == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	query := h.db.Where("id = ?", commentId)
+	if !HasPermission(c, permissions.COMMENT_MODERATE_ANY) {
+		query = query.Where("user_id = ?", c.GetInt64(keys.UserID))
+	}
+
+	result := query.Delete(&models.Comment{})
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": wrappers.NewErrNotFound("comment").Error()})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/feeds"
+	"github.com/noctispine/blog/pkg/responses"
+	"github.com/noctispine/blog/pkg/wrappers"
+	"gorm.io/gorm"
+)
+
+// number of latest posts in every feed
+const feedSize = 20
+
+type FeedHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+	baseURL string
+	title string
+}
+
+// feedPost is a published post together with its author's name.
+type feedPost struct {
+	models.Post `gorm:"embedded"`
+	FirstName string `gorm:"column:first_name"`
+	LastName string `gorm:"column:last_name"`
+}
+
+// NewFeedHandler builds feeds whose links start with baseURL, the configured
+// public URL, for example https://blog.example.com. Links are never built
+// from request headers, which anyone can set.
+func NewFeedHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, baseURL string, title string) *FeedHandler {
+	return &FeedHandler{
+		ctx,
+		db,
+		logger,
+		strings.TrimSuffix(baseURL, "/"),
+		title,
+	}
+}
+
+func postURL(baseURL string, slug string) string {
+	return baseURL + "/posts/" + slug
+}
+
+func categoryURL(baseURL string, slug string) string {
+	return baseURL + "/categories/" + slug
+}
+
+func tagURL(baseURL string, slug string) string {
+	return baseURL + "/tags/" + slug
+}
+
+func (h *FeedHandler) SiteFeed(format feeds.Format) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h.writeFeed(c, format, h.title, h.baseURL+"/", h.db)
+	}
+}
+
+// CategoryFeed serves the posts of a category and all of its subcategories.
+func (h *FeedHandler) CategoryFeed(format feeds.Format) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var category models.Category
+
+		if err := h.db.Where("slug = ?", c.Params.ByName("slug")).First(&category).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+					"error": wrappers.NewErrNotFound("category").Error()})
+				return
+			}
+
+			logError(c, h.logger, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		categoryIds, err := categoryTreeIds(h.db, category.ID, true)
+		if err != nil {
+			logError(c, h.logger, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		query := h.db.Where("post.id IN (?)", h.db.Table("post_category").Select("post_id").Where("category_id IN ?", categoryIds))
+		h.writeFeed(c, format, fmt.Sprintf("%s - %s", h.title, category.Title), categoryURL(h.baseURL, category.Slug), query)
+	}
+}
+
+func (h *FeedHandler) TagFeed(format feeds.Format) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var tag models.Tag
+
+		if err := h.db.Where("slug = ?", c.Params.ByName("slug")).First(&tag).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+					"error": wrappers.NewErrNotFound("tag").Error()})
+				return
+			}
+
+			logError(c, h.logger, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		query := h.db.Where("post.id IN (?)", h.db.Table("post_tag").Select("post_id").Where("tag_id = ?", tag.ID))
+		h.writeFeed(c, format, fmt.Sprintf("%s - %s", h.title, tag.Title), tagURL(h.baseURL, tag.Slug), query)
+	}
+}
+
+// writeFeed renders the latest published posts matching query. Feed readers
+// poll often, so the response carries an ETag and Last-Modified and unchanged
+// feeds are answered with 304.
+func (h *FeedHandler) writeFeed(c *gin.Context, format feeds.Format, title string, link string, query *gorm.DB) {
+	var posts []feedPost
+
+	if err := query.Model(&models.Post{}).
+		Select("post.*, user_account.first_name, user_account.last_name").
+		Joins("JOIN user_account ON user_account.id = post.user_id").
+		Where("post.is_published = ?", true).
+		Order("post.published_at desc, post.id desc").
+		Limit(feedSize).
+		Find(&posts).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	feed := feeds.Feed{
+		Title: title,
+		Link: link,
+		FeedURL: h.baseURL + c.Request.URL.Path,
+	}
+
+	for _, post := range posts {
+		published := post.CreatedAt
+		if post.PublishedAt != nil {
+			published = *post.PublishedAt
+		}
+
+		updated := post.UpdatedAt
+		if updated.Before(published) {
+			updated = published
+		}
+
+		if updated.After(feed.Updated) {
+			feed.Updated = updated
+		}
+
+		feed.Items = append(feed.Items, feeds.Item{
+			ID: postURL(h.baseURL, post.Slug),
+			Title: post.Title,
+			Link: postURL(h.baseURL, post.Slug),
+			Summary: post.Summary,
+			Content: post.Content,
+			Author: strings.TrimSpace(post.FirstName + " " + post.LastName),
+			Published: published,
+			Updated: updated,
+		})
+	}
+
+	// an empty feed keeps a fixed date so its ETag stays stable
+	lastModified := feed.Updated
+	if feed.Updated.IsZero() {
+		feed.Updated = time.Unix(0, 0)
+	}
+
+	body, err := feeds.Render(feed, format)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	responses.WriteConditional(c, feeds.ContentTypes[format], body, lastModified)
+}
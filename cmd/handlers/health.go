@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// how long the readiness probe waits for the database
+const readinessTimeout = 2 * time.Second
+
+// HealthReporter is implemented by background workers.
+type HealthReporter interface {
+	Healthy() error
+}
+
+type HealthHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+	workers map[string]HealthReporter
+	shuttingDown atomic.Bool
+}
+
+func NewHealthHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, workers map[string]HealthReporter) *HealthHandler {
+	return &HealthHandler{
+		ctx: ctx,
+		db: db,
+		logger: logger,
+		workers: workers,
+	}
+}
+
+// ShuttingDown makes the readiness probe fail, so no new traffic is routed
+// to this instance while in-flight requests finish.
+func (h *HealthHandler) ShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+// Live reports that the process is up and serving requests.
+func (h *HealthHandler) Live(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// Ready reports whether the instance can serve traffic: the database
+// answers and every background worker is healthy.
+func (h *HealthHandler) Ready(c *gin.Context) {
+	if h.shuttingDown.Load() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "shutting down"})
+		return
+	}
+
+	checks := gin.H{}
+	ready := true
+
+	sqlDB, err := h.db.DB()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+		err = sqlDB.PingContext(ctx)
+		cancel()
+	}
+
+	if err != nil {
+		checks["database"] = err.Error()
+		ready = false
+	} else {
+		checks["database"] = "ok"
+	}
+
+	for name, worker := range h.workers {
+		if err := worker.Healthy(); err != nil {
+			checks[name] = err.Error()
+			ready = false
+			continue
+		}
+
+		checks[name] = "ok"
+	}
+
+	if !ready {
+		h.logger.WarnContext(c.Request.Context(), "not ready", slog.Any("checks", checks))
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/config"
+	"github.com/noctispine/blog/cmd/constants/roles"
+	dbPackage "github.com/noctispine/blog/cmd/db"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/encryption"
+	"github.com/noctispine/blog/pkg/lockout"
+	"github.com/noctispine/blog/pkg/mail"
+	"github.com/noctispine/blog/pkg/passwords"
+	"github.com/noctispine/blog/pkg/revocation"
+	"github.com/noctispine/blog/pkg/signing"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// testDB connects to the database in TEST_DATABASE_DSN and migrates it. The
+// test is skipped without one. Tests share the database, so they make
+// their own rows, e.g. with a unique email, instead of expecting it empty.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{
+		DSN: dsn,
+		PreferSimpleProtocol: true,
+	}), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dbPackage.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// uniqueEmail keeps tests apart in the shared database.
+func uniqueEmail(t *testing.T) string {
+	t.Helper()
+
+	token, err := randomToken(8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return "test-" + token + "@example.com"
+}
+
+const testTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+func testTOTPSecrets(t *testing.T) *encryption.Key {
+	t.Helper()
+
+	key, err := encryption.NewKey("00112233445566778899aabbccddeeff")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func createTestUser(t *testing.T, db *gorm.DB, email string, twoFactor bool) models.UserAccount {
+	t.Helper()
+
+	now := time.Now()
+	user := models.UserAccount{
+		FirstName: "Ada",
+		LastName: "Lovelace",
+		Email: email,
+		PasswordHash: "unused",
+		Role: roles.BLOGGER,
+		RegisteredAt: now,
+	}
+	if err := db.Create(&user).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if twoFactor {
+		// the secret is bound to the user's id, which is known only now
+		secret, err := encryptTOTPSecret(testTOTPSecrets(t), user.ID, testTOTPSecret)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		user.TOTPSecret = &secret
+		user.TOTPEnabledAt = &now
+		if err := db.Model(&user).Select("totp_secret", "totp_enabled_at").Updates(&user).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return user
+}
+
+// testMailer keeps the messages instead of sending them.
+type testMailer struct {
+	mu sync.Mutex
+	messages []mail.Message
+}
+
+func (m *testMailer) Send(ctx context.Context, msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// last returns the newest message sent to the address. Some mails are sent
+// in the background, so it waits a moment for one.
+func (m *testMailer) last(t *testing.T, to string) mail.Message {
+	t.Helper()
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		m.mu.Lock()
+		for i := len(m.messages) - 1; i >= 0; i-- {
+			if m.messages[i].To == to {
+				m.mu.Unlock()
+				return m.messages[i]
+			}
+		}
+		m.mu.Unlock()
+	}
+
+	t.Fatalf("no mail was sent to %s", to)
+	return mail.Message{}
+}
+
+// count returns how many messages were sent to the address.
+func (m *testMailer) count(to string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	count := 0
+	for _, message := range m.messages {
+		if message.To == to {
+			count++
+		}
+	}
+	return count
+}
+
+// linkParam returns a query parameter of the link in a mail starting with
+// testBaseURL.
+func linkParam(t *testing.T, message mail.Message, param string) string {
+	t.Helper()
+
+	for _, field := range strings.Fields(message.Body) {
+		if strings.HasPrefix(field, testBaseURL+"/") {
+			link, err := url.Parse(field)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return link.Query().Get(param)
+		}
+	}
+
+	t.Fatalf("no link in %q", message.Body)
+	return ""
+}
+
+const testBaseURL = "http://blog.test"
+
+func newTestAuthHandler(t *testing.T, db *gorm.DB, mailer mail.Mailer) *AuthHandler {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	logger := testLogger()
+	keyRing, err := signing.NewKeyRing(db, logger, signing.RS256, 720*time.Hour, 2*time.Hour, "0123456789abcdef0123456789abcdef-signing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := keyRing.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	policy := lockout.Policy{Threshold: 5, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour}
+	return NewAuthHandler(context.Background(), db, logger, revocation.NewStore(db, logger), mailer, testBaseURL,
+		lockout.NewMemoryLimiter(policy), lockout.NewMemoryLimiter(policy), passwords.NewBcrypt(4), keyRing, testTOTPSecrets(t), config.JWT{
+			Secret: "0123456789abcdef0123456789abcdef",
+			ExpireMinutes: 15,
+			RefreshExpireHours: 24,
+			SigningAlgorithm: signing.RS256,
+		})
+}
+
+// serve runs a single handler on route as the signed in user, 0 for none.
+func serve(handler gin.HandlerFunc, method string, route string, target string, userId int64, body interface{}) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		if userId != 0 {
+			c.Set(keys.UserID, userId)
+		}
+		c.Next()
+	}, handler)
+
+	var payload bytes.Buffer
+	if body != nil {
+		json.NewEncoder(&payload).Encode(body)
+	}
+
+	request := httptest.NewRequest(method, target, &payload)
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"log/slog"
+
+	"github.com/gin-gonic/gin"
+)
+
+// logError logs an error that failed the request. Logging through the
+// request's context adds its id, route and user.
+func logError(c *gin.Context, logger *slog.Logger, err error) {
+	logger.ErrorContext(c.Request.Context(), "request failed", slog.Any("error", err))
+}
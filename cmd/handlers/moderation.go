@@ -0,0 +1,280 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/constants/moderation"
+	"github.com/noctispine/blog/cmd/constants/permissions"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/pagination"
+	"github.com/noctispine/blog/pkg/scopes"
+	"github.com/noctispine/blog/pkg/utils"
+	"github.com/noctispine/blog/pkg/wrappers"
+	"gorm.io/gorm"
+)
+
+type ModerationHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+}
+
+func NewModerationHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger) *ModerationHandler {
+	return &ModerationHandler{
+		ctx,
+		db,
+		logger,
+	}
+}
+
+// canModeratePost reports whether the current user may moderate comments
+// on the given post: moderators can moderate everything, authors their own posts.
+func canModeratePost(c *gin.Context, post models.Post) bool {
+	return HasPermission(c, permissions.COMMENT_MODERATE_ANY) || post.UserID == c.GetInt64(keys.UserID)
+}
+
+// resolveCommentStatus decides the status of a new or edited comment.
+// Comments from admins and from the post's author go live immediately.
+// Comments from known commenters, users with at least one approved comment,
+// go live when auto approval is enabled for the post or globally. Everything
+// else waits in the moderation queue.
+func resolveCommentStatus(db *gorm.DB, c *gin.Context, post models.Post) (string, error) {
+	if canModeratePost(c, post) {
+		return moderation.APPROVED, nil
+	}
+
+	setting, err := effectiveModerationSetting(db, post.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if !setting.AutoApproveKnown {
+		return moderation.PENDING, nil
+	}
+
+	var approvedCount int64
+	if err := db.Model(&models.Comment{}).Where("user_id = ? AND status = ?", c.GetInt64(keys.UserID), moderation.APPROVED).Count(&approvedCount).Error; err != nil {
+		return "", err
+	}
+
+	if approvedCount > 0 {
+		return moderation.APPROVED, nil
+	}
+
+	return moderation.PENDING, nil
+}
+
+// effectiveModerationSetting returns the post's own setting if it has one,
+// then the global setting, then the defaults.
+func effectiveModerationSetting(db *gorm.DB, postId int64) (models.ModerationSetting, error) {
+	var settings []models.ModerationSetting
+	if err := db.Where("post_id = ? OR post_id IS NULL", postId).Find(&settings).Error; err != nil {
+		return models.ModerationSetting{}, err
+	}
+
+	effective := models.ModerationSetting{}
+	for _, setting := range settings {
+		if setting.PostID != nil {
+			return setting, nil
+		}
+		effective = setting
+	}
+
+	return effective, nil
+}
+
+// Inbox lists comments waiting for a decision, oldest first. ?status= picks
+// another queue (approved, rejected, spam) and ?postId= narrows it to one post.
+// Admins see every post, authors only their own posts.
+func (h *ModerationHandler) Inbox(c *gin.Context) {
+	var comments []models.Comment
+	var pagination pagination.Pagination
+	pagination.Sort = "id asc"
+
+	status := c.DefaultQuery("status", moderation.PENDING)
+	if !utils.Contains(moderation.STATUSES, status) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "unknown comment status"})
+		return
+	}
+
+	query := h.db.Model(&models.Comment{}).Where("status = ?", status)
+
+	if postId, ok := c.GetQuery("postId"); ok {
+		query = query.Where("post_id = ?", postId)
+	}
+
+	if !HasPermission(c, permissions.COMMENT_MODERATE_ANY) {
+		query = query.Where("post_id IN (?)", h.db.Model(&models.Post{}).Select("id").Where("user_id = ?", c.GetInt64(keys.UserID)))
+	}
+
+	query = query.Session(&gorm.Session{})
+	result := query.Scopes(scopes.Paginate(models.Comment{}, &pagination, query, c)).Find(&comments)
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	pagination.Rows = comments
+	c.JSON(http.StatusOK, pagination)
+}
+
+func (h *ModerationHandler) Moderate(c *gin.Context) {
+	var decision models.ModerateComment
+
+	commentId := c.Params.ByName("id")
+	if commentId == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := c.ShouldBindJSON(&decision); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(decision); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	var comment models.Comment
+	if err := h.db.Where("id = ?", commentId).First(&comment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("comment").Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var post models.Post
+	if err := h.db.Select("id", "user_id").Where("id = ?", comment.PostID).First(&post).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if !canModeratePost(c, post) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return
+	}
+
+	if err := h.db.Model(&models.Comment{}).Where("id = ?", comment.ID).Updates(map[string]interface{}{
+		"status": decision.Status,
+		"moderated_by": c.GetInt64(keys.UserID),
+		"moderated_at": time.Now(),
+	}).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetSettings returns the effective settings for ?postId=, or the global
+// settings when no post is given.
+func (h *ModerationHandler) GetSettings(c *gin.Context) {
+	var postId int64
+
+	if raw, ok := c.GetQuery("postId"); ok {
+		var err error
+		if postId, err = strconv.ParseInt(raw, 10, 64); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	setting, err := effectiveModerationSetting(h.db, postId)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, setting)
+}
+
+// UpdateSettings stores the global settings when postId is omitted, which
+// only admins may do, or a per post override, which the post's author may
+// also do.
+func (h *ModerationHandler) UpdateSettings(c *gin.Context) {
+	var newSetting models.ModerationSetting
+
+	if err := c.ShouldBindJSON(&newSetting); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if newSetting.PostID == nil {
+		if !HasPermission(c, permissions.COMMENT_MODERATE_ANY) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+	} else {
+		var post models.Post
+		if err := h.db.Select("id", "user_id").Where("id = ?", *newSetting.PostID).First(&post).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+					"error": wrappers.NewErrNotFound("post").Error()})
+				return
+			}
+
+			logError(c, h.logger, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		if !canModeratePost(c, post) {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		var existing models.ModerationSetting
+
+		query := tx.Where("post_id IS NULL")
+		if newSetting.PostID != nil {
+			query = tx.Where("post_id = ?", *newSetting.PostID)
+		}
+
+		if err := query.First(&existing).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return tx.Omit("id").Create(&newSetting).Error
+			}
+			return err
+		}
+
+		return tx.Model(&models.ModerationSetting{}).Where("id = ?", existing.ID).Update("auto_approve_known", newSetting.AutoApproveKnown).Error
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgerrcode"
+	"github.com/noctispine/blog/cmd/constants/roles"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/utils"
+	"golang.org/x/oauth2"
+	"gorm.io/gorm"
+)
+
+const oidcLoginLifetime = 10 * time.Minute
+
+type OIDCConfig struct {
+	IssuerURL string
+	ClientID string
+	ClientSecret string
+	RedirectURL string
+	Scopes []string
+}
+
+// OIDCHandler signs users in through an OpenID Connect provider with the
+// authorization code flow and PKCE. The session it starts is the same as
+// the one SignInHandler starts.
+type OIDCHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+	auth *AuthHandler
+	config OIDCConfig
+
+	mu sync.Mutex
+	provider *oidc.Provider
+}
+
+func NewOIDCHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, auth *AuthHandler, config OIDCConfig) *OIDCHandler {
+	return &OIDCHandler{
+		ctx: ctx,
+		db: db,
+		logger: logger,
+		auth: auth,
+		config: config,
+	}
+}
+
+type oidcClaims struct {
+	Email string `json:"email"`
+	EmailVerified bool `json:"email_verified"`
+	GivenName string `json:"given_name"`
+	FamilyName string `json:"family_name"`
+	Name string `json:"name"`
+}
+
+// discover fetches the provider's configuration on first use, so the blog
+// still starts while the provider is unreachable.
+func (h *OIDCHandler) discover() (*oidc.Provider, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.provider != nil {
+		return h.provider, nil
+	}
+
+	provider, err := oidc.NewProvider(h.ctx, h.config.IssuerURL)
+	if err != nil {
+		return nil, err
+	}
+
+	h.provider = provider
+	return provider, nil
+}
+
+func (h *OIDCHandler) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID: h.config.ClientID,
+		ClientSecret: h.config.ClientSecret,
+		RedirectURL: h.config.RedirectURL,
+		Endpoint: provider.Endpoint(),
+		Scopes: h.config.Scopes,
+	}
+}
+
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Login redirects to the provider's authorization endpoint.
+func (h *OIDCHandler) Login(c *gin.Context) {
+	provider, err := h.discover()
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": "identity provider is unavailable"})
+		return
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := randomToken(32)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	verifier, err := randomToken(32)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now()
+	if err := h.db.Where("expires_at < ?", now).Delete(&models.OIDCLoginState{}).Error; err != nil {
+		logError(c, h.logger, err)
+	}
+
+	if err := h.db.Create(&models.OIDCLoginState{
+		StateHash: hashToken(state),
+		Nonce: nonce,
+		CodeVerifier: verifier,
+		CreatedAt: now,
+		ExpiresAt: now.Add(oidcLoginLifetime),
+	}).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Redirect(http.StatusFound, h.oauth2Config(provider).AuthCodeURL(state,
+		oidc.Nonce(nonce),
+		oauth2.SetAuthURLParam("code_challenge", pkceChallenge(verifier)),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"),
+	))
+}
+
+// Callback finishes the login the provider redirected back from. The
+// external identity is matched to a user by an earlier link, then by
+// verified email, and a new blogger account is created otherwise.
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": providerErr + ": " + c.Query("error_description")})
+		return
+	}
+
+	provider, err := h.discover()
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatusJSON(http.StatusBadGateway, gin.H{
+			"error": "identity provider is unavailable"})
+		return
+	}
+
+	var loginState models.OIDCLoginState
+	if err := h.db.Where("state_hash = ?", hashToken(c.Query("state"))).First(&loginState).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "login is invalid or expired"})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	result := h.db.Model(&models.OIDCLoginState{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", loginState.ID, time.Now()).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "login is invalid or expired"})
+		return
+	}
+
+	token, err := h.oauth2Config(provider).Exchange(c.Request.Context(), c.Query("code"),
+		oauth2.SetAuthURLParam("code_verifier", loginState.CodeVerifier))
+	if err != nil {
+		h.logger.WarnContext(c.Request.Context(), "exchanging the authorization code failed", slog.Any("error", err))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "authorization code was rejected"})
+		return
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "provider returned no id token"})
+		return
+	}
+
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: h.config.ClientID}).Verify(c.Request.Context(), rawIDToken)
+	if err != nil {
+		h.logger.WarnContext(c.Request.Context(), "id token was rejected", slog.Any("error", err))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "id token is invalid"})
+		return
+	}
+
+	if idToken.Nonce != loginState.Nonce {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "id token is invalid"})
+		return
+	}
+
+	var claims oidcClaims
+	if err := idToken.Claims(&claims); err != nil {
+		h.logger.WarnContext(c.Request.Context(), "id token was rejected", slog.Any("error", err))
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "id token is invalid"})
+		return
+	}
+
+	user, err := h.resolveUser(idToken.Issuer, idToken.Subject, claims)
+	if err != nil {
+		if errors.Is(err, errUnverifiedEmail) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": err.Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if user.DisabledAt != nil {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "Account is disabled"})
+		return
+	}
+
+	// the provider stands in for the password, not for the second factor
+	if user.TOTPEnabledAt != nil {
+		challengeToken, challengeExpires, err := createTwoFactorChallenge(h.db, user.ID)
+		if err != nil {
+			logError(c, h.logger, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"twoFactorRequired": true,
+			"challengeToken": challengeToken,
+			"challengeExpires": challengeExpires})
+		return
+	}
+
+	h.auth.completeSignIn(c, user)
+}
+
+var errUnverifiedEmail = errors.New("the identity provider hasn't verified this email address")
+
+func (h *OIDCHandler) resolveUser(issuer string, subject string, claims oidcClaims) (models.UserAccount, error) {
+	var user models.UserAccount
+	var identity models.ExternalIdentity
+	now := time.Now()
+
+	err := h.db.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error
+	if err == nil {
+		if err := h.db.Model(&models.ExternalIdentity{}).Where("id = ?", identity.ID).Update("last_login_at", now).Error; err != nil {
+			return user, err
+		}
+
+		err = h.db.Where("id = ?", identity.UserID).First(&user).Error
+		return user, err
+	}
+
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return user, err
+	}
+
+	// linking by an address the provider didn't verify would let anyone
+	// take over the account with that address
+	if claims.Email == "" || !claims.EmailVerified {
+		return user, errUnverifiedEmail
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("LOWER(email) = LOWER(?)", claims.Email).First(&user).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			user = newOIDCUser(claims, now)
+			err = tx.Create(&user).Error
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if user.VerifiedAt == nil {
+			if err := tx.Model(&models.UserAccount{}).Where("id = ?", user.ID).Update("verified_at", now).Error; err != nil {
+				return err
+			}
+			user.VerifiedAt = &now
+		}
+
+		return tx.Create(&models.ExternalIdentity{
+			UserID: user.ID,
+			Issuer: issuer,
+			Subject: subject,
+			Email: claims.Email,
+			CreatedAt: now,
+			LastLoginAt: &now,
+		}).Error
+	})
+
+	// a concurrent callback for the same identity linked it first
+	if utils.CheckPostgreError(err, pgerrcode.UniqueViolation) {
+		return h.resolveUser(issuer, subject, claims)
+	}
+
+	return user, err
+}
+
+// newOIDCUser builds a blogger account for a first login. It has no
+// password, one can be set through the reset flow.
+func newOIDCUser(claims oidcClaims, now time.Time) models.UserAccount {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" {
+		firstName, lastName, _ = strings.Cut(claims.Name, " ")
+	}
+
+	if firstName == "" {
+		firstName, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	return models.UserAccount{
+		FirstName: truncate(firstName, 50),
+		LastName: truncate(lastName, 50),
+		Email: claims.Email,
+		Role: roles.BLOGGER,
+		RegisteredAt: now,
+		VerifiedAt: &now,
+	}
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) > max {
+		return string(runes[:max])
+	}
+
+	return s
+}
@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/noctispine/blog/cmd/constants/roles"
+	"github.com/noctispine/blog/cmd/models"
+	"gorm.io/gorm"
+)
+
+const testClientID = "blog"
+
+// mockIssuer is an OpenID provider serving discovery, its JWKS and a token
+// endpoint that checks PKCE. Authorizations are registered by the test in
+// place of a user signing in at the provider.
+type mockIssuer struct {
+	server *httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	codes map[string]mockAuthorization
+}
+
+type mockAuthorization struct {
+	challenge string
+	nonce string
+	subject string
+	claims map[string]interface{}
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	issuer := &mockIssuer{key: key, codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", issuer.discovery)
+	mux.HandleFunc("/jwks", issuer.jwks)
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+
+	return issuer
+}
+
+func (m *mockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer": m.server.URL,
+		"authorization_endpoint": m.server.URL + "/authorize",
+		"token_endpoint": m.server.URL + "/token",
+		"jwks_uri": m.server.URL + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n": base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	m.mu.Lock()
+	authorization, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	if !ok || pkceChallenge(r.PostForm.Get("code_verifier")) != authorization.challenge {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": m.server.URL,
+		"aud": testClientID,
+		"sub": authorization.subject,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+		"nonce": authorization.nonce,
+	}
+	for name, value := range authorization.claims {
+		claims[name] = value
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(m.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "access",
+		"token_type": "Bearer",
+		"expires_in": 3600,
+		"id_token": signed,
+	})
+}
+
+// authorize stands in for the user signing in at the provider: it takes the
+// redirect Login answered with and returns the state and code the provider
+// would send back. tamper changes what the provider saw, e.g. the nonce.
+func (m *mockIssuer) authorize(t *testing.T, location string, subject string, claims map[string]interface{}, tamper func(*mockAuthorization)) (string, string) {
+	t.Helper()
+
+	redirect, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	query := redirect.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("code_challenge_method = %q, want S256", query.Get("code_challenge_method"))
+	}
+
+	authorization := mockAuthorization{
+		challenge: query.Get("code_challenge"),
+		nonce: query.Get("nonce"),
+		subject: subject,
+		claims: claims,
+	}
+	if tamper != nil {
+		tamper(&authorization)
+	}
+
+	code, err := randomToken(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	m.codes[code] = authorization
+	m.mu.Unlock()
+
+	return query.Get("state"), code
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+type oidcTest struct {
+	db *gorm.DB
+	issuer *mockIssuer
+	router *gin.Engine
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	db := testDB(t)
+	issuer := newMockIssuer(t)
+
+	auth := newTestAuthHandler(t, db, &testMailer{})
+
+	handler := NewOIDCHandler(context.Background(), db, testLogger(), auth, OIDCConfig{
+		IssuerURL: issuer.server.URL,
+		ClientID: testClientID,
+		ClientSecret: "secret",
+		RedirectURL: "http://blog.test/user/oidc/callback",
+		Scopes: []string{"openid", "email", "profile"},
+	})
+
+	router := gin.New()
+	router.GET("/user/oidc/login", handler.Login)
+	router.GET("/user/oidc/callback", handler.Callback)
+
+	return &oidcTest{db, issuer, router}
+}
+
+func (o *oidcTest) login(t *testing.T) string {
+	t.Helper()
+
+	recorder := httptest.NewRecorder()
+	o.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/user/oidc/login", nil))
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("login answered %d: %s", recorder.Code, recorder.Body)
+	}
+
+	return recorder.Header().Get("Location")
+}
+
+func (o *oidcTest) callback(t *testing.T, state string, code string) (int, map[string]interface{}) {
+	t.Helper()
+
+	target := "/user/oidc/callback?" + url.Values{"state": {state}, "code": {code}}.Encode()
+	recorder := httptest.NewRecorder()
+	o.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, target, nil))
+
+	var body map[string]interface{}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	return recorder.Code, body
+}
+
+func TestOIDCCallback(t *testing.T) {
+	o := newOIDCTest(t)
+
+	tests := []struct {
+		name string
+		// an account with the email exists before the login
+		existing bool
+		twoFactor bool
+		emailVerified bool
+		tamper func(*mockAuthorization)
+		wantStatus int
+		wantLinked bool
+		check func(t *testing.T, body map[string]interface{}, user models.UserAccount)
+	}{
+		{
+			name: "first login creates an account",
+			emailVerified: true,
+			wantStatus: http.StatusOK,
+			wantLinked: true,
+			check: func(t *testing.T, body map[string]interface{}, user models.UserAccount) {
+				if body["token"] == nil || body["refreshToken"] == nil {
+					t.Errorf("body = %v, want tokens", body)
+				}
+				if user.Role != roles.BLOGGER || user.VerifiedAt == nil {
+					t.Errorf("created user = %+v, want a verified blogger", user)
+				}
+			},
+		},
+		{
+			name: "verified email links an existing account",
+			existing: true,
+			emailVerified: true,
+			wantStatus: http.StatusOK,
+			wantLinked: true,
+		},
+		{
+			name: "unverified email is not linked",
+			existing: true,
+			emailVerified: false,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name: "two-factor users get the challenge",
+			existing: true,
+			twoFactor: true,
+			emailVerified: true,
+			wantStatus: http.StatusOK,
+			wantLinked: true,
+			check: func(t *testing.T, body map[string]interface{}, user models.UserAccount) {
+				if body["twoFactorRequired"] != true || body["challengeToken"] == nil {
+					t.Errorf("body = %v, want a two-factor challenge", body)
+				}
+				if body["token"] != nil {
+					t.Errorf("body = %v, got tokens before the second factor", body)
+				}
+			},
+		},
+		{
+			name: "PKCE verifier mismatch",
+			emailVerified: true,
+			tamper: func(a *mockAuthorization) { a.challenge = pkceChallenge("another verifier") },
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "nonce mismatch",
+			emailVerified: true,
+			tamper: func(a *mockAuthorization) { a.nonce = "another nonce" },
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := uniqueEmail(t)
+			if tt.existing {
+				createTestUser(t, o.db, email, tt.twoFactor)
+			}
+
+			subject := "subject-" + email
+			state, code := o.issuer.authorize(t, o.login(t), subject, map[string]interface{}{
+				"email": email,
+				"email_verified": tt.emailVerified,
+				"given_name": "Ada",
+				"family_name": "Lovelace",
+			}, tt.tamper)
+
+			status, body := o.callback(t, state, code)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %v", status, tt.wantStatus, body)
+			}
+
+			var identities int64
+			if err := o.db.Model(&models.ExternalIdentity{}).
+				Where("issuer = ? AND subject = ?", o.issuer.server.URL, subject).
+				Count(&identities).Error; err != nil {
+				t.Fatal(err)
+			}
+			if linked := identities == 1; linked != tt.wantLinked {
+				t.Errorf("identity linked = %v, want %v", linked, tt.wantLinked)
+			}
+
+			var user models.UserAccount
+			err := o.db.Where("email = ?", email).First(&user).Error
+			if tt.existing || tt.wantLinked {
+				if err != nil {
+					t.Fatalf("loading the user: %v", err)
+				}
+			} else if err == nil {
+				t.Errorf("failed login created user %d", user.ID)
+			}
+
+			if tt.check != nil {
+				tt.check(t, body, user)
+			}
+		})
+	}
+}
+
+func TestOIDCCallbackReplayedState(t *testing.T) {
+	o := newOIDCTest(t)
+
+	email := uniqueEmail(t)
+	state, code := o.issuer.authorize(t, o.login(t), "subject-"+email, map[string]interface{}{
+		"email": email,
+		"email_verified": true,
+	}, nil)
+
+	if status, body := o.callback(t, state, code); status != http.StatusOK {
+		t.Fatalf("first callback status = %d: %v", status, body)
+	}
+
+	if status, body := o.callback(t, state, code); status != http.StatusBadRequest {
+		t.Errorf("replayed callback status = %d, want %d: %v", status, http.StatusBadRequest, body)
+	}
+}
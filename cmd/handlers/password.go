@@ -0,0 +1,259 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/lockout"
+	"github.com/noctispine/blog/pkg/mail"
+	"github.com/noctispine/blog/pkg/passwords"
+	"github.com/noctispine/blog/pkg/revocation"
+	"gorm.io/gorm"
+)
+
+const passwordResetLifetime = time.Hour
+
+type PasswordHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+	revocations *revocation.Store
+	mailer mail.Mailer
+	baseURL string
+	hasher passwords.Hasher
+	// reset requests per email and per client IP
+	emailLimiter lockout.Limiter
+	ipLimiter lockout.Limiter
+}
+
+func NewPasswordHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, revocations *revocation.Store, mailer mail.Mailer, baseURL string, hasher passwords.Hasher, emailLimiter lockout.Limiter, ipLimiter lockout.Limiter) *PasswordHandler {
+	return &PasswordHandler{
+		ctx,
+		db,
+		logger,
+		revocations,
+		mailer,
+		strings.TrimSuffix(baseURL, "/"),
+		hasher,
+		emailLimiter,
+		ipLimiter,
+	}
+}
+
+// Forgot mails a reset link to the address if it belongs to an account. The
+// answer is the same either way, so the endpoint can't be used to find out
+// which emails are registered. Requests are throttled per email and per
+// client IP, whether or not the email is known.
+func (h *PasswordHandler) Forgot(c *gin.Context) {
+	var forgot models.ForgotPassword
+
+	if err := c.ShouldBindJSON(&forgot); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(forgot); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	// the same address in any case is throttled and found alike
+	email := strings.ToLower(forgot.Email)
+
+	if !h.throttle(c, email) {
+		return
+	}
+
+	var user models.UserAccount
+	err := h.db.Where("LOWER(email) = ?", email).First(&user).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err == nil {
+		if err := sendPasswordReset(c, h.db, h.logger, h.mailer, h.baseURL, user); err != nil {
+			logError(c, h.logger, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message": "If the email belongs to an account, a reset link is on its way"})
+}
+
+// throttle counts a reset request against the email and the client. It
+// answers 429 and returns false while either of them is locked out.
+func (h *PasswordHandler) throttle(c *gin.Context, email string) bool {
+	emailKey := "reset:" + email
+	ipKey := "reset-ip:" + c.ClientIP()
+
+	var waits []time.Duration
+	for _, check := range []struct {
+		limiter lockout.Limiter
+		key string
+	}{{h.emailLimiter, emailKey}, {h.ipLimiter, ipKey}} {
+		wait, err := check.limiter.Check(c.Request.Context(), check.key)
+		if err != nil {
+			logError(c, h.logger, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return false
+		}
+		waits = append(waits, wait)
+	}
+
+	if !abortTooManyRequests(c, "Too many password reset requests, try again later", waits...) {
+		return false
+	}
+
+	if _, err := h.emailLimiter.Fail(c.Request.Context(), emailKey); err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return false
+	}
+
+	if _, err := h.ipLimiter.Fail(c.Request.Context(), ipKey); err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return false
+	}
+
+	return true
+}
+
+// sendPasswordReset creates a reset link for the user and mails it. Sending
+// happens in the background, so Forgot answers in the same time whether or
+// not the account exists.
+func sendPasswordReset(c *gin.Context, db *gorm.DB, logger *slog.Logger, mailer mail.Mailer, baseURL string, user models.UserAccount) error {
+	token, err := createResetToken(db, user.ID)
+	if err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/reset-password?token=%s", baseURL, token)
+	ctx := c.Request.Context()
+
+	go func() {
+		if err := mailer.Send(context.Background(), mail.Message{
+			To: user.Email,
+			Subject: "Reset your password",
+			Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes.\n\n%s\n\nIf you didn't ask for this you can ignore this email.\n",
+				user.FirstName, int(passwordResetLifetime.Minutes()), link),
+		}); err != nil {
+			logger.ErrorContext(ctx, "sending password reset failed", slog.Any("error", err))
+		}
+	}()
+
+	return nil
+}
+
+// createResetToken invalidates earlier reset links of the user and creates
+// a new one.
+func createResetToken(db *gorm.DB, userId int64) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.PasswordResetToken{}).
+			Where("user_id = ? AND used_at IS NULL", userId).
+			Update("expires_at", now).Error; err != nil {
+			return err
+		}
+
+		return tx.Create(&models.PasswordResetToken{
+			UserID: userId,
+			TokenHash: hashToken(token),
+			CreatedAt: now,
+			ExpiresAt: now.Add(passwordResetLifetime),
+		}).Error
+	})
+
+	return token, err
+}
+
+// Reset sets a new password with a token from a reset link. The token can be
+// used once, and every existing session of the user is signed out.
+func (h *PasswordHandler) Reset(c *gin.Context) {
+	var reset models.ResetPassword
+
+	if err := c.ShouldBindJSON(&reset); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(reset); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	hashedPassword, err := h.hasher.Hash(reset.Password)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var resetToken models.PasswordResetToken
+	invalid := false
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.PasswordResetToken{}).
+			Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", hashToken(reset.Token), time.Now()).
+			Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+
+		if result.RowsAffected == 0 {
+			invalid = true
+			return nil
+		}
+
+		if err := tx.Where("token_hash = ?", hashToken(reset.Token)).First(&resetToken).Error; err != nil {
+			return err
+		}
+
+		return tx.Model(&models.UserAccount{}).Where("id = ?", resetToken.UserID).Updates(map[string]interface{}{
+			"password_hash": hashedPassword,
+			"password_reset_required": false,
+		}).Error
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if invalid {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "reset token is invalid or expired"})
+		return
+	}
+
+	if err := revokeSessions(h.db, h.revocations, resetToken.UserID); err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
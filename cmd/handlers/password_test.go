@@ -0,0 +1,106 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/lockout"
+	"github.com/noctispine/blog/pkg/passwords"
+)
+
+func newTestPasswordHandler(t *testing.T, mailer *testMailer) (*PasswordHandler, *AuthHandler) {
+	t.Helper()
+
+	db := testDB(t)
+	auth := newTestAuthHandler(t, db, mailer)
+	policy := lockout.Policy{Threshold: 5, BaseDelay: time.Second, MaxDelay: time.Minute, Window: time.Hour}
+
+	return NewPasswordHandler(context.Background(), db, testLogger(), auth.revocations, mailer, testBaseURL, passwords.NewBcrypt(4),
+		lockout.NewMemoryLimiter(policy), lockout.NewMemoryLimiter(policy)), auth
+}
+
+// requestReset asks for a reset link and returns the token mailed to the
+// user.
+func requestReset(t *testing.T, h *PasswordHandler, mailer *testMailer, user models.UserAccount, email string) string {
+	t.Helper()
+
+	sent := mailer.count(user.Email)
+
+	recorder := serve(h.Forgot, http.MethodPost, "/user/password/forgot", "/user/password/forgot", 0, models.ForgotPassword{Email: email})
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("forgot answered %d: %s", recorder.Code, recorder.Body)
+	}
+
+	for deadline := time.Now().Add(2 * time.Second); mailer.count(user.Email) == sent; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("no reset link was mailed for %s", email)
+		}
+	}
+
+	return linkParam(t, mailer.last(t, user.Email), "token")
+}
+
+func resetPassword(h *PasswordHandler, token string, password string) int {
+	return serve(h.Reset, http.MethodPost, "/user/password/reset", "/user/password/reset", 0, models.ResetPassword{Token: token, Password: password}).Code
+}
+
+func TestPasswordResetTokenSingleUse(t *testing.T) {
+	mailer := &testMailer{}
+	h, auth := newTestPasswordHandler(t, mailer)
+	user := createTestUser(t, h.db, uniqueEmail(t), false)
+	claims := issueTestToken(t, auth, user)
+
+	token := requestReset(t, h, mailer, user, user.Email)
+
+	if code := resetPassword(h, token, "new password"); code != http.StatusNoContent {
+		t.Fatalf("reset answered %d, want %d", code, http.StatusNoContent)
+	}
+
+	var stored models.UserAccount
+	if err := h.db.Where("id = ?", user.ID).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := h.hasher.Verify("new password", stored.PasswordHash); err != nil || !ok {
+		t.Errorf("the new password doesn't verify: %v", err)
+	}
+
+	if code := resetPassword(h, token, "another password"); code != http.StatusBadRequest {
+		t.Errorf("reusing the token answered %d, want %d", code, http.StatusBadRequest)
+	}
+
+	if !auth.revocations.IsRevoked(claims.ID, user.ID, claims.IssuedAt.Time) {
+		t.Error("access tokens from before the reset still pass")
+	}
+}
+
+func TestPasswordResetOnlyNewestLinkWorks(t *testing.T) {
+	mailer := &testMailer{}
+	h, _ := newTestPasswordHandler(t, mailer)
+	user := createTestUser(t, h.db, uniqueEmail(t), false)
+
+	first := requestReset(t, h, mailer, user, user.Email)
+	second := requestReset(t, h, mailer, user, user.Email)
+
+	if code := resetPassword(h, first, "new password"); code != http.StatusBadRequest {
+		t.Errorf("the replaced link answered %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := resetPassword(h, second, "new password"); code != http.StatusNoContent {
+		t.Errorf("the newest link answered %d, want %d", code, http.StatusNoContent)
+	}
+}
+
+func TestPasswordResetIgnoresEmailCase(t *testing.T) {
+	mailer := &testMailer{}
+	h, _ := newTestPasswordHandler(t, mailer)
+	user := createTestUser(t, h.db, uniqueEmail(t), false)
+
+	token := requestReset(t, h, mailer, user, strings.ToUpper(user.Email))
+
+	if code := resetPassword(h, token, "new password"); code != http.StatusNoContent {
+		t.Errorf("reset answered %d, want %d", code, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,182 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/wrappers"
+	"gorm.io/gorm"
+)
+
+// PersonalAccessTokenPrefix marks personal access tokens, so they can be told
+// apart from JWTs in the Authorization header.
+const PersonalAccessTokenPrefix = "blog_pat_"
+
+const defaultPersonalAccessTokenDays = 30
+
+// last_used_at is written at most this often per token
+const personalAccessTokenTouchInterval = time.Minute
+
+var ErrInvalidPersonalAccessToken = errors.New("invalid personal access token")
+
+type PersonalAccessTokenHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+}
+
+func NewPersonalAccessTokenHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger) *PersonalAccessTokenHandler {
+	return &PersonalAccessTokenHandler{
+		ctx,
+		db,
+		logger,
+	}
+}
+
+// AuthenticatePersonalAccessToken returns the owner and the scopes of an
+// active personal access token.
+func AuthenticatePersonalAccessToken(ctx context.Context, db *gorm.DB, logger *slog.Logger, token string) (models.UserAccount, []string, error) {
+	var pat models.PersonalAccessToken
+	var user models.UserAccount
+
+	if err := db.Where("token_hash = ?", hashToken(token)).First(&pat).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return user, nil, ErrInvalidPersonalAccessToken
+		}
+
+		return user, nil, err
+	}
+
+	now := time.Now()
+	if pat.RevokedAt != nil || now.After(pat.ExpiresAt) {
+		return user, nil, ErrInvalidPersonalAccessToken
+	}
+
+	if err := db.Where("id = ?", pat.UserID).First(&user).Error; err != nil {
+		return user, nil, err
+	}
+
+	if user.DisabledAt != nil {
+		return user, nil, ErrInvalidPersonalAccessToken
+	}
+
+	if err := db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", pat.ID, now.Add(-personalAccessTokenTouchInterval)).
+		Update("last_used_at", now).Error; err != nil {
+		logger.ErrorContext(ctx, "touching personal access token failed", slog.Any("error", err))
+	}
+
+	return user, strings.Fields(pat.Scopes), nil
+}
+
+// revokeUserPersonalAccessTokens revokes every token of the user, for when
+// all of their sessions are signed out.
+func revokeUserPersonalAccessTokens(tx *gorm.DB, userId int64) error {
+	return tx.Model(&models.PersonalAccessToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+}
+
+// Create issues a token for the signed in user. The token is only part of
+// this response, just its hash is stored.
+func (h *PersonalAccessTokenHandler) Create(c *gin.Context) {
+	var request models.CreatePersonalAccessToken
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(request); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	if request.ExpiresInDays == 0 {
+		request.ExpiresInDays = defaultPersonalAccessTokenDays
+	}
+
+	secret, err := randomToken(32)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+	token := PersonalAccessTokenPrefix + secret
+
+	now := time.Now()
+	pat := models.PersonalAccessToken{
+		UserID: c.GetInt64(keys.UserID),
+		Name: request.Name,
+		TokenHash: hashToken(token),
+		Scopes: strings.Join(request.Scopes, " "),
+		CreatedAt: now,
+		ExpiresAt: now.AddDate(0, 0, request.ExpiresInDays),
+	}
+
+	if err := h.db.Create(&pat).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token": token,
+		"personalAccessToken": pat})
+}
+
+// GetAll lists the signed in user's tokens, revoked and expired ones included.
+func (h *PersonalAccessTokenHandler) GetAll(c *gin.Context) {
+	var tokens []models.PersonalAccessToken
+
+	if err := h.db.Where("user_id = ?", c.GetInt64(keys.UserID)).Order("created_at DESC").Find(&tokens).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Delete revokes one of the signed in user's tokens.
+func (h *PersonalAccessTokenHandler) Delete(c *gin.Context) {
+	tokenId, err := strconv.ParseInt(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	var pat models.PersonalAccessToken
+	if err := h.db.Where("id = ? AND user_id = ?", tokenId, c.GetInt64(keys.UserID)).First(&pat).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("token").Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.db.Model(&models.PersonalAccessToken{}).
+		Where("id = ? AND revoked_at IS NULL", pat.ID).
+		Update("revoked_at", time.Now()).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
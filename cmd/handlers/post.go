@@ -2,29 +2,38 @@ package handlers
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgerrcode"
+	"github.com/noctispine/blog/cmd/constants/permissions"
 	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/cmd/workers"
 	"github.com/noctispine/blog/pkg/constants/keys"
 	"github.com/noctispine/blog/pkg/pagination"
 	"github.com/noctispine/blog/pkg/scopes"
 	"github.com/noctispine/blog/pkg/utils"
+	"github.com/noctispine/blog/pkg/wrappers"
 	"gorm.io/gorm"
 )
 
 type PostHandler struct {
 	ctx context.Context
 	db *gorm.DB
+	logger *slog.Logger
+	publisher *workers.Publisher
 }
 
-func NewPostHandler(ctx context.Context, db *gorm.DB) *PostHandler {
+func NewPostHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, publisher *workers.Publisher) *PostHandler {
 	return &PostHandler{
 		ctx: ctx,
 		db: db,
+		logger: logger,
+		publisher: publisher,
 	}
 }
 
@@ -43,6 +52,12 @@ func (h *PostHandler) GetAll(c *gin.Context) {
 		return
 	}
 
+	if err := attachTaxonomies(h.db, postPointers(posts)...); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
+
 	c.JSON(http.StatusOK, &posts)
 }
 
@@ -57,7 +72,13 @@ func (h *PostHandler) GetPage(c *gin.Context) {
 	}
 
 	if result.Error != nil {
-		log.Println(result.Error)
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := attachTaxonomies(h.db, postPointers(posts)...); err != nil {
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -70,14 +91,190 @@ func (h *PostHandler) GetPageByCategory(c *gin.Context) {
 	var posts []models.Post
 	var pagination pagination.Pagination
 
-	categoryId := c.Params.ByName("id")
-	if categoryId == "" {
+	categoryId, err := strconv.ParseInt(c.Params.ByName("id"), 10, 64)
+	if err != nil {
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
 
-	h.db.Scopes(scopes.Paginate(posts, &pagination, h.db, c)).Joins("post_category", h.db.Model("post_category").Where("category_id = ?", categoryId)).Find(&posts)
-	log.Println(posts)
+	includeDescendants := false
+	if raw, ok := c.GetQuery("includeDescendants"); ok {
+		if includeDescendants, err = strconv.ParseBool(raw); err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+	}
+
+	categoryIds, err := categoryTreeIds(h.db, categoryId, includeDescendants)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if len(categoryIds) == 0 {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": wrappers.NewErrNotFound("category").Error()})
+		return
+	}
+
+	query := h.db.Model(&models.Post{}).
+		Where("is_published = ?", true).
+		Where("id IN (?)", h.db.Table("post_category").Select("post_id").Where("category_id IN ?", categoryIds)).
+		Session(&gorm.Session{})
+
+	result := query.Scopes(scopes.Paginate(posts, &pagination, query, c)).Find(&posts)
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := attachTaxonomies(h.db, postPointers(posts)...); err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	pagination.Rows = posts
+	c.JSON(http.StatusOK, pagination)
+}
+
+func (h *PostHandler) GetPageByTag(c *gin.Context) {
+	var posts []models.Post
+	var pagination pagination.Pagination
+	var tag models.Tag
+
+	if err := h.db.Where("slug = ?", c.Params.ByName("slug")).First(&tag).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("tag").Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	query := h.db.Model(&models.Post{}).
+		Where("is_published = ?", true).
+		Where("id IN (?)", h.db.Table("post_tag").Select("post_id").Where("tag_id = ?", tag.ID)).
+		Session(&gorm.Session{})
+
+	result := query.Scopes(scopes.Paginate(posts, &pagination, query, c)).Find(&posts)
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := attachTaxonomies(h.db, postPointers(posts)...); err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	pagination.Rows = posts
+	c.JSON(http.StatusOK, pagination)
+}
+
+type postTagRow struct {
+	PostID int64 `gorm:"column:post_id"`
+	models.Tag `gorm:"embedded"`
+}
+
+type postCategoryRow struct {
+	PostID int64 `gorm:"column:post_id"`
+	models.Category `gorm:"embedded"`
+}
+
+func postPointers(posts []models.Post) []*models.Post {
+	pointers := make([]*models.Post, len(posts))
+	for i := range posts {
+		pointers[i] = &posts[i]
+	}
+	return pointers
+}
+
+// attachTaxonomies fills in the tags and categories of the posts with one
+// query each, so listing a page doesn't cost a query per post.
+func attachTaxonomies(db *gorm.DB, posts ...*models.Post) error {
+	if len(posts) == 0 {
+		return nil
+	}
+
+	byId := make(map[int64]*models.Post, len(posts))
+	postIds := make([]int64, len(posts))
+	for i, post := range posts {
+		post.Tags = []models.Tag{}
+		post.Categories = []models.Category{}
+		byId[post.ID] = post
+		postIds[i] = post.ID
+	}
+
+	var tagRows []postTagRow
+	if err := db.Table("tag").
+		Select("post_tag.post_id, tag.*").
+		Joins("JOIN post_tag ON post_tag.tag_id = tag.id").
+		Where("post_tag.post_id IN ?", postIds).
+		Order("tag.title").
+		Scan(&tagRows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range tagRows {
+		post := byId[row.PostID]
+		post.Tags = append(post.Tags, row.Tag)
+	}
+
+	var categoryRows []postCategoryRow
+	if err := db.Table("category").
+		Select("post_category.post_id, category.*").
+		Joins("JOIN post_category ON post_category.category_id = category.id").
+		Where("post_category.post_id IN ?", postIds).
+		Order("category.title").
+		Scan(&categoryRows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range categoryRows {
+		post := byId[row.PostID]
+		post.Categories = append(post.Categories, row.Category)
+	}
+
+	return nil
+}
+
+// categoryTreeIds returns the id of the category itself and, when
+// includeDescendants is set, the ids of every category below it by
+// following parent_id. An empty result means the category doesn't exist.
+func categoryTreeIds(db *gorm.DB, categoryId int64, includeDescendants bool) ([]int64, error) {
+	var ids []int64
+
+	if !includeDescendants {
+		err := db.Model(&models.Category{}).Where("id = ?", categoryId).Pluck("id", &ids).Error
+		return ids, err
+	}
+
+	err := db.Raw(`WITH RECURSIVE tree AS (
+			SELECT id FROM category WHERE id = ?
+			UNION
+			SELECT category.id FROM category JOIN tree ON category.parent_id = tree.id
+		)
+		SELECT id FROM tree`, categoryId).Scan(&ids).Error
+
+	return ids, err
 }
 
 func (h *PostHandler) Create(c *gin.Context) {
@@ -97,20 +294,43 @@ func (h *PostHandler) Create(c *gin.Context) {
 		return
 	}
 
+	// a publishedAt on a new post schedules it, publishing right away goes
+	// through TogglePublish
+	if post.PublishedAt != nil && !post.PublishedAt.After(time.Now()) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "publishedAt must be in the future"})
+		return
+	}
+
 	post.Slug = utils.ConstructSlug(post.Title)
+	post.IsPublished = false
+	post.Tags = []models.Tag{}
+	post.Categories = []models.Category{}
 
-	if err := h.db.Omit("id", "created_at", "updated_at", "published_at", "is_published").Create(&post).Error; err != nil {
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit("id", "created_at", "updated_at", "is_published").Create(&post).Error; err != nil {
+			return err
+		}
+
+		return savePostRevision(tx, post, post.UserID, nil)
+	})
+
+	if err != nil {
 		if utils.CheckPostgreError(err, pgerrcode.UniqueViolation) {
 			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
 				"error": "The title is already exists"})
 			return
 		}
 		
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
+	if post.PublishedAt != nil {
+		h.publisher.Wake()
+	}
+
 	c.JSON(http.StatusCreated, post)
 }
 
@@ -132,14 +352,14 @@ func (h *PostHandler) Update(c *gin.Context) {
 	}
 
 	var post models.Post
-	if err := h.db.Model(&models.Post{}).Where("id = ?", updatePost.ID).First(&post).Error; err != nil {
+	if err := h.db.Model(&models.Post{}).Scopes(ownedPosts(c, permissions.POST_EDIT_ANY)).Where("id = ?", updatePost.ID).First(&post).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
 				"error": "post doesnt exist"})
 			return
 		}
 
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -149,8 +369,21 @@ func (h *PostHandler) Update(c *gin.Context) {
 	if post.Title != updatePost.Title {
 		updatePost.Slug = utils.ConstructSlug(updatePost.Title)
 	}
-	
-	if err := h.db.Model(&models.Post{}).Where("user_id = ?", c.GetInt64(keys.UserID)).Omit("id", "created_at", "user_id").Updates(&updatePost).Error; err != nil {
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Post{}).Where("id = ?", post.ID).Omit("id", "created_at", "user_id", "published_at", "is_published").Updates(&updatePost).Error; err != nil {
+			return err
+		}
+
+		var updated models.Post
+		if err := tx.Where("id = ?", post.ID).First(&updated).Error; err != nil {
+			return err
+		}
+
+		return savePostRevision(tx, updated, c.GetInt64(keys.UserID), nil)
+	})
+
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": err.Error()})
 		return
@@ -168,20 +401,30 @@ func (h *PostHandler) TogglePublish(c *gin.Context) {
 	}
 
 	var post models.Post
-	if err := h.db.Model(&models.Post{}).Where("user_id = ? AND id = ?", c.GetInt64(keys.UserID), postId).First(&post).Error; err != nil {
+	if err := h.db.Model(&models.Post{}).Scopes(ownedPosts(c, permissions.POST_EDIT_ANY)).Where("id = ?", postId).First(&post).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
 				"error": "post doesnt exist"})
 			return
 		}
 
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
 
-	if err := h.db.Model(&models.Post{}).Where("user_id = ? AND id = ?", c.GetInt64(keys.UserID), postId).Update("is_published", !post.IsPublished).Error; err != nil {
-		log.Println(err.Error())
+	// see models.Post for how published_at follows is_published
+	var publishedAt *time.Time
+	if !post.IsPublished {
+		now := time.Now()
+		publishedAt = &now
+	}
+
+	if err := h.db.Model(&models.Post{}).Where("id = ? AND is_published = ?", post.ID, post.IsPublished).Updates(map[string]interface{}{
+		"is_published": !post.IsPublished,
+		"published_at": publishedAt,
+	}).Error; err != nil {
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -189,6 +432,77 @@ func (h *PostHandler) TogglePublish(c *gin.Context) {
 	c.Status(http.StatusOK)
 }
 
+// Schedule sets a future publishedAt on an unpublished post, replacing any
+// earlier schedule.
+func (h *PostHandler) Schedule(c *gin.Context) {
+	var schedule models.SchedulePost
+
+	postId := c.Params.ByName("id")
+	if postId == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := c.ShouldBindJSON(&schedule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(schedule); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	if !schedule.PublishedAt.After(time.Now()) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "publishedAt must be in the future"})
+		return
+	}
+
+	result := h.db.Model(&models.Post{}).Scopes(ownedPosts(c, permissions.POST_EDIT_ANY)).Where("id = ? AND is_published = ?", postId, false).Update("published_at", schedule.PublishedAt)
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "unpublished post doesnt exist"})
+		return
+	}
+
+	h.publisher.Wake()
+	c.Status(http.StatusNoContent)
+}
+
+// Unschedule turns a scheduled post back into a draft.
+func (h *PostHandler) Unschedule(c *gin.Context) {
+	postId := c.Params.ByName("id")
+	if postId == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	result := h.db.Model(&models.Post{}).Scopes(ownedPosts(c, permissions.POST_EDIT_ANY)).Where("id = ? AND is_published = ?", postId, false).Update("published_at", nil)
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+			"error": "unpublished post doesnt exist"})
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
 func (h *PostHandler) Delete(c *gin.Context) {
 	postId := c.Params.ByName("id")
 	
@@ -197,14 +511,14 @@ func (h *PostHandler) Delete(c *gin.Context) {
 		return
 	}
 	
-	if err := h.db.Where("user_id = ?", c.GetInt64(keys.UserID)).Delete(&models.Post{}, postId).Error; err != nil {
+	if err := h.db.Scopes(ownedPosts(c, permissions.POST_DELETE_ANY)).Delete(&models.Post{}, postId).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
 				"error": "post doesnt exist"})
 			return
 		}
 
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -2,7 +2,7 @@ package handlers
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -14,12 +14,14 @@ import (
 type PostCategoryHandler struct {
 	ctx context.Context
 	db *gorm.DB
+	logger *slog.Logger
 }
 
-func NewPostCategoryHandler(ctx context.Context, db *gorm.DB) *PostCategoryHandler {
+func NewPostCategoryHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger) *PostCategoryHandler {
 	return &PostCategoryHandler{
 		ctx,
 		db,
+		logger,
 	}
 }
 
@@ -40,7 +42,7 @@ func (h *PostCategoryHandler) Create(c *gin.Context) {
 	if err := h.db.Table("post_category").Create(map[string]interface{}{
 		"post_id": postId, "category_id": categoryId,
 	  }).Error; err != nil {
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -64,7 +66,7 @@ func (h *PostCategoryHandler) Delete(c *gin.Context) {
 
 
 	if err := h.db.Table("post_category").Where("post_id = ? AND category_id = ?", postId, categoryId).Delete(&models.PostCategory{}).Error; err != nil {
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
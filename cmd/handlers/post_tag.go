@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgerrcode"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/utils"
+	"github.com/noctispine/blog/pkg/wrappers"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type PostTagHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+}
+
+func NewPostTagHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger) *PostTagHandler {
+	return &PostTagHandler{
+		ctx,
+		db,
+		logger,
+	}
+}
+
+// Set replaces all tags of the post with the given ones.
+func (h *PostTagHandler) Set(c *gin.Context) {
+	var postId string
+	var ok bool
+	var setTags models.SetPostTags
+
+	if postId, ok = c.GetQuery("postId"); !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := c.ShouldBindJSON(&setTags); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(setTags); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	tagIds := make([]int64, 0, len(setTags.TagIDs))
+	for _, tagId := range setTags.TagIDs {
+		if !utils.Contains(tagIds, tagId) {
+			tagIds = append(tagIds, tagId)
+		}
+	}
+
+	var existing int64
+	if err := h.db.Model(&models.Tag{}).Where("id IN ?", tagIds).Count(&existing).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if int(existing) != len(tagIds) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": wrappers.NewErrDoesNotExist("tag").Error()})
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Table("post_tag").Where("post_id = ?", postId).Delete(&models.PostTag{}).Error; err != nil {
+			return err
+		}
+
+		for _, tagId := range tagIds {
+			if err := tx.Table("post_tag").Create(map[string]interface{}{
+				"post_id": postId, "tag_id": tagId,
+			}).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *PostTagHandler) Create(c *gin.Context) {
+	var tagId, postId string
+	var ok bool
+
+	if tagId, ok = c.GetQuery("tagId"); !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if postId, ok = c.GetQuery("postId"); !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.Table("post_tag").Clauses(clause.OnConflict{DoNothing: true}).Create(map[string]interface{}{
+		"post_id": postId, "tag_id": tagId,
+	}).Error; err != nil {
+		if utils.CheckPostgreError(err, pgerrcode.ForeignKeyViolation) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": wrappers.NewErrDoesNotExist("tag").Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusCreated)
+}
+
+func (h *PostTagHandler) Delete(c *gin.Context) {
+	var tagId, postId string
+	var ok bool
+
+	if tagId, ok = c.GetQuery("tagId"); !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if postId, ok = c.GetQuery("postId"); !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if err := h.db.Table("post_tag").Where("post_id = ? AND tag_id = ?", postId, tagId).Delete(&models.PostTag{}).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
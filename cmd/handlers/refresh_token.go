@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"github.com/noctispine/blog/cmd/models"
+	"gorm.io/gorm"
+)
+
+func randomToken(size int) (string, error) {
+	bytes := make([]byte, size)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(bytes), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// issueRefreshToken stores a new refresh token for the user and returns the
+// plain token, which is never stored. An empty familyId starts a new family,
+// i.e. a new session.
+func issueRefreshToken(tx *gorm.DB, userId int64, familyId string, lifetime time.Duration) (string, time.Time, error) {
+	if familyId == "" {
+		bytes := make([]byte, 16)
+		if _, err := rand.Read(bytes); err != nil {
+			return "", time.Time{}, err
+		}
+		familyId = hex.EncodeToString(bytes)
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	refreshToken := models.RefreshToken{
+		UserID: userId,
+		FamilyID: familyId,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(lifetime),
+	}
+
+	if err := tx.Create(&refreshToken).Error; err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, refreshToken.ExpiresAt, nil
+}
+
+func revokeRefreshTokenFamily(tx *gorm.DB, familyId string) error {
+	return tx.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyId).
+		Update("revoked_at", time.Now()).Error
+}
+
+func revokeUserRefreshTokens(tx *gorm.DB, userId int64) error {
+	return tx.Model(&models.RefreshToken{}).
+		Where("user_id = ? AND revoked_at IS NULL", userId).
+		Update("revoked_at", time.Now()).Error
+}
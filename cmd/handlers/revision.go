@@ -0,0 +1,231 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgerrcode"
+	"github.com/noctispine/blog/cmd/constants/permissions"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/diff"
+	"github.com/noctispine/blog/pkg/pagination"
+	"github.com/noctispine/blog/pkg/scopes"
+	"github.com/noctispine/blog/pkg/utils"
+	"github.com/noctispine/blog/pkg/wrappers"
+	"gorm.io/gorm"
+)
+
+type RevisionHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+}
+
+func NewRevisionHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger) *RevisionHandler {
+	return &RevisionHandler{
+		ctx,
+		db,
+		logger,
+	}
+}
+
+// savePostRevision records the current state of the post as a new revision.
+func savePostRevision(tx *gorm.DB, post models.Post, userId int64, restoredFrom *int64) error {
+	return tx.Create(&models.PostRevision{
+		PostID: post.ID,
+		UserID: userId,
+		Title: post.Title,
+		Summary: post.Summary,
+		Content: post.Content,
+		RestoredFrom: restoredFrom,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+// findEditablePost loads the post from the :id param if the current user
+// owns it or may edit any post, otherwise it aborts the request.
+func (h *RevisionHandler) findEditablePost(c *gin.Context) (models.Post, bool) {
+	var post models.Post
+
+	postId := c.Params.ByName("id")
+	if postId == "" {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return post, false
+	}
+
+	if err := h.db.Where("id = ?", postId).First(&post).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("post").Error()})
+			return post, false
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return post, false
+	}
+
+	if post.UserID != c.GetInt64(keys.UserID) && !HasPermission(c, permissions.POST_EDIT_ANY) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return post, false
+	}
+
+	return post, true
+}
+
+func (h *RevisionHandler) findRevision(c *gin.Context, postId int64, revisionId string) (models.PostRevision, bool) {
+	var revision models.PostRevision
+
+	if err := h.db.Where("id = ? AND post_id = ?", revisionId, postId).First(&revision).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("revision").Error()})
+			return revision, false
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return revision, false
+	}
+
+	return revision, true
+}
+
+// GetPage lists the revisions of a post, newest first.
+func (h *RevisionHandler) GetPage(c *gin.Context) {
+	var revisions []models.PostRevision
+	var pagination pagination.Pagination
+
+	post, ok := h.findEditablePost(c)
+	if !ok {
+		return
+	}
+
+	query := h.db.Model(&models.PostRevision{}).Where("post_id = ?", post.ID).Session(&gorm.Session{})
+	result := query.Scopes(scopes.Paginate(models.PostRevision{}, &pagination, query, c)).Find(&revisions)
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	pagination.Rows = revisions
+	c.JSON(http.StatusOK, pagination)
+}
+
+// Diff compares two revisions of a post, ?from= and ?to=, line by line.
+func (h *RevisionHandler) Diff(c *gin.Context) {
+	var fromId, toId string
+	var ok bool
+
+	if fromId, ok = c.GetQuery("from"); !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	if toId, ok = c.GetQuery("to"); !ok {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return
+	}
+
+	post, ok := h.findEditablePost(c)
+	if !ok {
+		return
+	}
+
+	from, ok := h.findRevision(c, post.ID, fromId)
+	if !ok {
+		return
+	}
+
+	to, ok := h.findRevision(c, post.ID, toId)
+	if !ok {
+		return
+	}
+
+	fields := gin.H{}
+	for name, sides := range map[string][2]string{
+		"title": {from.Title, to.Title},
+		"summary": {from.Summary, to.Summary},
+		"content": {from.Content, to.Content},
+	} {
+		lines, err := diff.Lines(sides[0], sides[1])
+		if errors.Is(err, diff.ErrTooLarge) {
+			c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{
+				"error": fmt.Sprintf("Revisions longer than %d lines can't be compared", diff.MaxLines)})
+			return
+		}
+
+		fields[name] = lines
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from": from.ID,
+		"to": to.ID,
+		"title": fields["title"],
+		"summary": fields["summary"],
+		"content": fields["content"],
+	})
+}
+
+// Restore copies a revision back onto the post. The restored content is saved
+// as a new revision so the history is never rewritten.
+func (h *RevisionHandler) Restore(c *gin.Context) {
+	post, ok := h.findEditablePost(c)
+	if !ok {
+		return
+	}
+
+	revision, ok := h.findRevision(c, post.ID, c.Params.ByName("revisionId"))
+	if !ok {
+		return
+	}
+
+	if post.Title != revision.Title {
+		post.Slug = utils.ConstructSlug(revision.Title)
+	}
+
+	post.Title = revision.Title
+	post.Summary = revision.Summary
+	post.Content = revision.Content
+	post.UpdatedAt = time.Now()
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Post{}).Where("id = ?", post.ID).Updates(map[string]interface{}{
+			"title": post.Title,
+			"slug": post.Slug,
+			"summary": post.Summary,
+			"content": post.Content,
+			"updated_at": post.UpdatedAt,
+		}).Error; err != nil {
+			return err
+		}
+
+		return savePostRevision(tx, post, c.GetInt64(keys.UserID), &revision.ID)
+	})
+
+	if err != nil {
+		if utils.CheckPostgreError(err, pgerrcode.UniqueViolation) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The title is already exists"})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, post)
+}
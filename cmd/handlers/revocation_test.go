@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/constants/roles"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/revocation"
+)
+
+// issueTestToken signs an access token for the user and returns its claims.
+func issueTestToken(t *testing.T, auth *AuthHandler, user models.UserAccount) *Claims {
+	t.Helper()
+
+	token, _, err := auth.issueAccessToken(auth.db, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := ParseAccessToken(auth.keys, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return claims
+}
+
+func TestSessionsRevoked(t *testing.T) {
+	db := testDB(t)
+	mailer := &testMailer{}
+	auth := newTestAuthHandler(t, db, mailer)
+	users := NewUserHandler(context.Background(), db, testLogger(), auth.revocations, mailer, testBaseURL)
+	admin := createTestUser(t, db, uniqueEmail(t), false)
+
+	tests := []struct {
+		name string
+		handler gin.HandlerFunc
+		method string
+		route string
+		// signs in as the user instead of the admin
+		self bool
+		body interface{}
+	}{
+		{"log out everywhere", auth.LogoutAll, http.MethodPost, "/user/logout-all", true, nil},
+		{"revoke sessions", auth.RevokeUserSessions, http.MethodPost, "/admin/users/:id/revoke-sessions", false, nil},
+		{"disable", users.Disable, http.MethodPost, "/admin/users/:id/disable", false, models.DisableUser{Reason: "test"}},
+		{"force password reset", users.ForcePasswordReset, http.MethodPost, "/admin/users/:id/password-reset", false, nil},
+		{"change role", users.ChangeRole, http.MethodPut, "/admin/users/:id/role", false, map[string]int{"roleId": roles.ADMIN}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := createTestUser(t, db, uniqueEmail(t), false)
+			// issued in the same second as the revocation most of the time
+			claims := issueTestToken(t, auth, user)
+
+			actor := admin.ID
+			if tt.self {
+				actor = user.ID
+			}
+
+			target := "/admin/users/" + strconv.FormatInt(user.ID, 10) + tt.route[len("/admin/users/:id"):]
+			if tt.self {
+				target = tt.route
+			}
+
+			recorder := serve(tt.handler, tt.method, tt.route, target, actor, tt.body)
+			if recorder.Code >= 300 {
+				t.Fatalf("answered %d: %s", recorder.Code, recorder.Body)
+			}
+
+			if !auth.revocations.IsRevoked(claims.ID, user.ID, claims.IssuedAt.Time) {
+				t.Error("the access token still passes on this instance")
+			}
+
+			// other instances learn about it from the database
+			other := revocation.NewStore(db, testLogger())
+			if err := other.Load(); err != nil {
+				t.Fatal(err)
+			}
+			if !other.IsRevoked(claims.ID, user.ID, claims.IssuedAt.Time) {
+				t.Error("the access token still passes on another instance")
+			}
+		})
+	}
+}
+
+func TestSessionsKeptOnRollback(t *testing.T) {
+	db := testDB(t)
+	mailer := &testMailer{}
+	auth := newTestAuthHandler(t, db, mailer)
+	users := NewUserHandler(context.Background(), db, testLogger(), auth.revocations, mailer, testBaseURL)
+	admin := createTestUser(t, db, uniqueEmail(t), false)
+	user := createTestUser(t, db, uniqueEmail(t), false)
+	claims := issueTestToken(t, auth, user)
+
+	// the unknown role fails the transaction after the revocation was written
+	target := "/admin/users/" + strconv.FormatInt(user.ID, 10) + "/role"
+	recorder := serve(users.ChangeRole, http.MethodPut, "/admin/users/:id/role", target, admin.ID, map[string]int{"roleId": 1 << 30})
+	if recorder.Code != http.StatusBadRequest {
+		t.Fatalf("answered %d, want %d", recorder.Code, http.StatusBadRequest)
+	}
+
+	if auth.revocations.IsRevoked(claims.ID, user.ID, claims.IssuedAt.Time) {
+		t.Error("the cache kept a revocation that was rolled back")
+	}
+
+	var stored models.UserAccount
+	if err := db.Select("tokens_valid_after").Where("id = ?", user.ID).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.TokensValidAfter != nil {
+		t.Errorf("tokens_valid_after = %v, want it unset", stored.TokensValidAfter)
+	}
+}
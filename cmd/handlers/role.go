@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgerrcode"
+	"github.com/noctispine/blog/cmd/constants/permissions"
+	"github.com/noctispine/blog/cmd/constants/roles"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/revocation"
+	"github.com/noctispine/blog/pkg/utils"
+	"github.com/noctispine/blog/pkg/wrappers"
+	"gorm.io/gorm"
+)
+
+type RoleHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+	revocations *revocation.Store
+}
+
+func NewRoleHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, revocations *revocation.Store) *RoleHandler {
+	return &RoleHandler{
+		ctx,
+		db,
+		logger,
+		revocations,
+	}
+}
+
+// RolePermissions returns the permissions granted to a role.
+func RolePermissions(db *gorm.DB, roleId int) ([]string, error) {
+	granted := []string{}
+
+	err := db.Model(&models.RolePermission{}).Where("role_id = ?", roleId).Order("permission").Pluck("permission", &granted).Error
+	return granted, err
+}
+
+// HasPermission reports whether the current user's role grants the permission.
+func HasPermission(c *gin.Context, permission string) bool {
+	return utils.Contains(c.GetStringSlice(keys.UserPermissions), permission)
+}
+
+// ownedPosts limits a post query to the current user's posts, unless the
+// user holds the permission to act on any post.
+func ownedPosts(c *gin.Context, anyPermission string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if HasPermission(c, anyPermission) {
+			return db
+		}
+
+		return db.Where("user_id = ?", c.GetInt64(keys.UserID))
+	}
+}
+
+func unknownPermission(granted []string) string {
+	for _, permission := range granted {
+		if !utils.Contains(permissions.PERMISSIONS, permission) {
+			return permission
+		}
+	}
+
+	return ""
+}
+
+// setRolePermissions replaces the permissions of a role. Access tokens of the
+// role's users carry the old permissions, so they are revoked and the next
+// refresh picks up the new ones.
+func setRolePermissions(tx *gorm.DB, revoked *revocation.Pending, roleId int, granted []string) error {
+	if err := tx.Where("role_id = ?", roleId).Delete(&models.RolePermission{}).Error; err != nil {
+		return err
+	}
+
+	rows := []models.RolePermission{}
+	seen := map[string]bool{}
+	for _, permission := range granted {
+		if !seen[permission] {
+			seen[permission] = true
+			rows = append(rows, models.RolePermission{RoleID: roleId, Permission: permission})
+		}
+	}
+
+	if len(rows) > 0 {
+		if err := tx.Create(&rows).Error; err != nil {
+			return err
+		}
+	}
+
+	var userIds []int64
+	if err := tx.Model(&models.UserAccount{}).Where("role = ?", roleId).Pluck("id", &userIds).Error; err != nil {
+		return err
+	}
+
+	for _, userId := range userIds {
+		if err := revoked.RevokeUser(tx, userId); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (h *RoleHandler) findRole(c *gin.Context) (models.Role, bool) {
+	var role models.Role
+
+	roleId, err := strconv.Atoi(c.Params.ByName("id"))
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return role, false
+	}
+
+	if err := h.db.Where("id = ?", roleId).First(&role).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("role").Error()})
+			return role, false
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return role, false
+	}
+
+	return role, true
+}
+
+// GetPermissions lists every permission a role can be granted.
+func (h *RoleHandler) GetPermissions(c *gin.Context) {
+	c.JSON(http.StatusOK, permissions.PERMISSIONS)
+}
+
+func (h *RoleHandler) GetAll(c *gin.Context) {
+	var roleList []models.Role
+
+	if err := h.db.Order("id").Find(&roleList).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	var grants []models.RolePermission
+	if err := h.db.Order("permission").Find(&grants).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	for i := range roleList {
+		roleList[i].Permissions = []string{}
+		for _, grant := range grants {
+			if grant.RoleID == roleList[i].ID {
+				roleList[i].Permissions = append(roleList[i].Permissions, grant.Permission)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, roleList)
+}
+
+func (h *RoleHandler) Create(c *gin.Context) {
+	var request models.CreateRole
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(request); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	if permission := unknownPermission(request.Permissions); permission != "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "unknown permission " + permission})
+		return
+	}
+
+	role := models.Role{
+		Name: request.Name,
+		Description: request.Description,
+	}
+
+	revoked := h.revocations.Pending()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&role).Error; err != nil {
+			return err
+		}
+
+		return setRolePermissions(tx, revoked, role.ID, request.Permissions)
+	})
+
+	if err != nil {
+		if utils.CheckPostgreError(err, pgerrcode.UniqueViolation) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": "The role is already exists"})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	revoked.Apply()
+	role.Permissions, _ = RolePermissions(h.db, role.ID)
+	c.JSON(http.StatusCreated, role)
+}
+
+// Update changes the description and the permissions of a role. The admin
+// role is fixed, so there is always a role that can manage the others.
+func (h *RoleHandler) Update(c *gin.Context) {
+	var request models.UpdateRole
+
+	role, ok := h.findRole(c)
+	if !ok {
+		return
+	}
+
+	if role.ID == roles.ADMIN {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "the admin role can't be changed"})
+		return
+	}
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(request); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	if permission := unknownPermission(request.Permissions); permission != "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "unknown permission " + permission})
+		return
+	}
+
+	revoked := h.revocations.Pending()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.Role{}).Where("id = ?", role.ID).Update("description", request.Description).Error; err != nil {
+			return err
+		}
+
+		return setRolePermissions(tx, revoked, role.ID, request.Permissions)
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	revoked.Apply()
+	role.Description = request.Description
+	role.Permissions, _ = RolePermissions(h.db, role.ID)
+	c.JSON(http.StatusOK, role)
+}
+
+// Delete removes a role nobody holds. Built-in roles can't be deleted.
+func (h *RoleHandler) Delete(c *gin.Context) {
+	role, ok := h.findRole(c)
+	if !ok {
+		return
+	}
+
+	if role.BuiltIn {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "built-in roles can't be deleted"})
+		return
+	}
+
+	var holders int64
+	if err := h.db.Model(&models.UserAccount{}).Where("role = ?", role.ID).Count(&holders).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if holders > 0 {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "the role is still assigned to users"})
+		return
+	}
+
+	if err := h.db.Delete(&models.Role{}, role.ID).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"context"
+	"html"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/pagination"
+	"github.com/noctispine/blog/pkg/scopes"
+	"gorm.io/gorm"
+)
+
+// ts_headline copies the content as it is, markup included, so matches are
+// marked with private use characters and turned into <mark> only after the
+// snippet was escaped
+const (
+	snippetStart = "\ue000"
+	snippetStop = "\ue001"
+	snippetOptions = "MaxFragments=2, MaxWords=30, MinWords=10, StartSel=\"" + snippetStart + "\", StopSel=\"" + snippetStop + "\""
+)
+
+var snippetMarks = strings.NewReplacer(snippetStart, "<mark>", snippetStop, "</mark>")
+
+// escapeSnippet makes a snippet safe to render as HTML, only the <mark>
+// around the matches is markup.
+func escapeSnippet(snippet string) string {
+	return snippetMarks.Replace(html.EscapeString(snippet))
+}
+
+type SearchHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+	language string
+}
+
+func NewSearchHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, language string) *SearchHandler {
+	return &SearchHandler{
+		ctx,
+		db,
+		logger,
+		language,
+	}
+}
+
+// SearchPosts runs ?q= against published posts. The query accepts web search
+// syntax ("quoted phrases", -excluded, or). Title matches weigh more than
+// summary matches, which weigh more than content matches.
+func (h *SearchHandler) SearchPosts(c *gin.Context) {
+	var results []models.PostSearchResult
+	var pagination pagination.Pagination
+	pagination.Sort = "rank desc, id desc"
+
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "q is required"})
+		return
+	}
+
+	tsQuery := gorm.Expr("websearch_to_tsquery(?::regconfig, ?)", h.language, q)
+
+	query := h.db.Model(&models.Post{}).
+		Where("is_published = ? AND search_vector @@ ?", true, tsQuery).
+		Session(&gorm.Session{})
+
+	result := query.
+		Select("post.*, ts_rank_cd(search_vector, ?) AS rank, ts_headline(?::regconfig, content, ?, ?) AS snippet",
+			tsQuery, h.language, tsQuery, snippetOptions).
+		Scopes(scopes.Paginate(models.Post{}, &pagination, query, c)).
+		Find(&results)
+
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	posts := make([]*models.Post, len(results))
+	for i := range results {
+		results[i].Snippet = escapeSnippet(results[i].Snippet)
+		posts[i] = &results[i].Post
+	}
+
+	if err := attachTaxonomies(h.db, posts...); err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	pagination.Rows = results
+	c.JSON(http.StatusOK, pagination)
+}
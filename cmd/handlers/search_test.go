@@ -0,0 +1,25 @@
+package handlers
+
+import "testing"
+
+func TestEscapeSnippet(t *testing.T) {
+	tests := []struct {
+		name string
+		snippet string
+		want string
+	}{
+		{"plain", "a " + snippetStart + "match" + snippetStop + " here", "a <mark>match</mark> here"},
+		{"markup in the content", "<script>alert(1)</script> " + snippetStart + "match" + snippetStop, "&lt;script&gt;alert(1)&lt;/script&gt; <mark>match</mark>"},
+		{"markup in a match", snippetStart + "<b>" + snippetStop, "<mark>&lt;b&gt;</mark>"},
+		{"quotes and entities", `"Tom" & 'Jerry'`, "&#34;Tom&#34; &amp; &#39;Jerry&#39;"},
+		{"literal mark tags stay text", "<mark>x</mark>", "&lt;mark&gt;x&lt;/mark&gt;"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := escapeSnippet(tt.snippet); got != tt.want {
+				t.Errorf("escapeSnippet = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/pkg/responses"
+	"github.com/noctispine/blog/pkg/sitemap"
+	"gorm.io/gorm"
+)
+
+const sitemapContentType = "application/xml; charset=utf-8"
+
+// every public page in the order it appears across sitemap files: published
+// posts, then categories, then tags. Categories and tags take the newest
+// update of their published posts as lastmod.
+const sitemapEntriesQuery = `SELECT kind, slug, lastmod FROM (
+		SELECT 1 AS ord, id, 'post' AS kind, slug, updated_at AS lastmod
+		FROM post WHERE is_published = true
+		UNION ALL
+		SELECT 2, category.id, 'category', category.slug, (
+			SELECT max(post.updated_at) FROM post
+			JOIN post_category ON post_category.post_id = post.id
+			WHERE post_category.category_id = category.id AND post.is_published = true
+		)
+		FROM category
+		UNION ALL
+		SELECT 3, tag.id, 'tag', tag.slug, (
+			SELECT max(post.updated_at) FROM post
+			JOIN post_tag ON post_tag.post_id = post.id
+			WHERE post_tag.tag_id = tag.id AND post.is_published = true
+		)
+		FROM tag
+	) AS entries
+	ORDER BY ord, id
+	LIMIT ? OFFSET ?`
+
+type SitemapHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+	baseURL string
+}
+
+type sitemapEntry struct {
+	Kind string
+	Slug string
+	LastMod *time.Time `gorm:"column:lastmod"`
+}
+
+// NewSitemapHandler lists URLs starting with baseURL, the configured public
+// URL. They are never built from request headers, which anyone can set.
+func NewSitemapHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, baseURL string) *SitemapHandler {
+	return &SitemapHandler{
+		ctx,
+		db,
+		logger,
+		strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+func (h *SitemapHandler) countEntries() (int64, error) {
+	var count int64
+	err := h.db.Raw(`SELECT
+		(SELECT count(*) FROM post WHERE is_published = true)
+		+ (SELECT count(*) FROM category)
+		+ (SELECT count(*) FROM tag)`).Scan(&count).Error
+
+	return count, err
+}
+
+func (h *SitemapHandler) entries(page int) ([]sitemapEntry, error) {
+	var entries []sitemapEntry
+	err := h.db.Raw(sitemapEntriesQuery, sitemap.MaxURLs, (page-1)*sitemap.MaxURLs).Scan(&entries).Error
+
+	return entries, err
+}
+
+// Sitemap serves /sitemap.xml. As long as every page fits in one file it is a
+// plain sitemap, past the protocol limit it becomes an index of numbered
+// sitemaps served by Page.
+func (h *SitemapHandler) Sitemap(c *gin.Context) {
+	count, err := h.countEntries()
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if count <= sitemap.MaxURLs {
+		h.writePage(c, 1)
+		return
+	}
+
+	var lastMod time.Time
+	if err := h.db.Raw("SELECT coalesce(max(updated_at), to_timestamp(0)) FROM post WHERE is_published = true").Scan(&lastMod).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	pages := int((count + sitemap.MaxURLs - 1) / sitemap.MaxURLs)
+	sitemaps := make([]sitemap.URL, pages)
+	for i := range sitemaps {
+		sitemaps[i] = sitemap.URL{Loc: fmt.Sprintf("%s/sitemaps/%d.xml", h.baseURL, i+1), LastMod: &lastMod}
+	}
+
+	body, err := sitemap.RenderIndex(sitemaps)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	responses.WriteConditional(c, sitemapContentType, body, lastMod)
+}
+
+// Page serves /sitemaps/<n>.xml, the numbered sitemaps listed in the index.
+func (h *SitemapHandler) Page(c *gin.Context) {
+	page, err := strconv.Atoi(strings.TrimSuffix(c.Params.ByName("file"), ".xml"))
+	if err != nil || page < 1 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	h.writePage(c, page)
+}
+
+func (h *SitemapHandler) writePage(c *gin.Context, page int) {
+	entries, err := h.entries(page)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if len(entries) == 0 && page > 1 {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	urls := make([]sitemap.URL, len(entries))
+	var lastMod time.Time
+
+	for i, entry := range entries {
+		urls[i] = sitemap.URL{LastMod: entry.LastMod}
+
+		switch entry.Kind {
+		case "post":
+			urls[i].Loc = postURL(h.baseURL, entry.Slug)
+		case "category":
+			urls[i].Loc = categoryURL(h.baseURL, entry.Slug)
+		default:
+			urls[i].Loc = tagURL(h.baseURL, entry.Slug)
+		}
+
+		if entry.LastMod != nil && entry.LastMod.After(lastMod) {
+			lastMod = *entry.LastMod
+		}
+	}
+
+	body, err := sitemap.RenderURLSet(urls)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	responses.WriteConditional(c, sitemapContentType, body, lastMod)
+}
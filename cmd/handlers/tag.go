@@ -2,7 +2,7 @@ package handlers
 
 import (
 	"context"
-	"log"
+	"log/slog"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -15,12 +15,14 @@ import (
 type TagHandler struct {
 	ctx context.Context
 	db *gorm.DB
+	logger *slog.Logger
 }
 
-func NewTagHandler(ctx context.Context, db *gorm.DB) *TagHandler {
+func NewTagHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger) *TagHandler {
 	return &TagHandler{
 		ctx,
 		db,
+		logger,
 	}
 }
 
@@ -35,7 +37,7 @@ func (h *TagHandler) GetAll(c *gin.Context) {
 	}
 	
 	if result.Error != nil {
-		log.Println(result.Error.Error())
+		logError(c, h.logger, result.Error)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -47,7 +49,7 @@ func (h *TagHandler) Create(c *gin.Context) {
 	var newTag models.Tag
 
 	if err := c.ShouldBindJSON(&newTag); err != nil {
-		log.Println(err.Error())
+		h.logger.DebugContext(c.Request.Context(), "invalid request body", slog.Any("error", err))
 		c.AbortWithStatus(http.StatusBadRequest)
 		return
 	}
@@ -68,7 +70,7 @@ func (h *TagHandler) Create(c *gin.Context) {
 			return
 		}
 
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -119,7 +121,7 @@ func (h *TagHandler) Update(c *gin.Context) {
 			return
 		}
 
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
@@ -129,7 +131,7 @@ func (h *TagHandler) Update(c *gin.Context) {
 	}
 
 	if err := h.db.Where("id = ?", updateTag.ID).Updates(&updateTag).Error; err != nil {
-		log.Println(err.Error())
+		logError(c, h.logger, err)
 		c.AbortWithStatus(http.StatusInternalServerError)
 		return
 	}
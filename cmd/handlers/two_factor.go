@@ -0,0 +1,577 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/encryption"
+	"github.com/noctispine/blog/pkg/totp"
+	"github.com/noctispine/blog/pkg/wrappers"
+	"github.com/skip2/go-qrcode"
+	"gorm.io/gorm"
+)
+
+const twoFactorChallengeLifetime = 5 * time.Minute
+
+// codes a challenge accepts before it has to be started over with the password
+const twoFactorChallengeAttempts = 5
+
+const recoveryCodeCount = 10
+
+// number of steps before and after the current one a TOTP code may be from
+const totpSkew = 1
+
+type TwoFactorHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+	issuer string
+	secrets *encryption.Key
+}
+
+// NewTwoFactorHandler stores TOTP secrets encrypted with secrets.
+func NewTwoFactorHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, issuer string, secrets *encryption.Key) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		ctx,
+		db,
+		logger,
+		issuer,
+		secrets,
+	}
+}
+
+// createTwoFactorChallenge stores a challenge for a user who passed the
+// password check and returns the plain token, which is never stored.
+func createTwoFactorChallenge(db *gorm.DB, userId int64) (string, time.Time, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	now := time.Now()
+	challenge := models.TwoFactorChallenge{
+		UserID: userId,
+		TokenHash: hashToken(token),
+		CreatedAt: now,
+		ExpiresAt: now.Add(twoFactorChallengeLifetime),
+	}
+
+	if err := db.Create(&challenge).Error; err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, challenge.ExpiresAt, nil
+}
+
+// deleteTwoFactorChallenges drops the user's pending challenges, so a
+// password check passed before the account was locked can't be completed.
+func deleteTwoFactorChallenges(tx *gorm.DB, userId int64) error {
+	return tx.Where("user_id = ?", userId).Delete(&models.TwoFactorChallenge{}).Error
+}
+
+// totpSecretContext binds an encrypted TOTP secret to its user, so it can't
+// be copied to another account.
+func totpSecretContext(userId int64) string {
+	return "totp:" + strconv.FormatInt(userId, 10)
+}
+
+func encryptTOTPSecret(secrets *encryption.Key, userId int64, secret string) (string, error) {
+	return secrets.Encrypt([]byte(secret), totpSecretContext(userId))
+}
+
+// decryptTOTPSecret returns the user's TOTP secret. Secrets stored before
+// they were encrypted are returned as they are until EncryptTOTPSecrets ran.
+func decryptTOTPSecret(secrets *encryption.Key, user models.UserAccount) (string, error) {
+	if !encryption.IsEncrypted(*user.TOTPSecret) {
+		return *user.TOTPSecret, nil
+	}
+
+	secret, err := secrets.Decrypt(*user.TOTPSecret, totpSecretContext(user.ID))
+	if err != nil {
+		return "", fmt.Errorf("decrypting the TOTP secret of user %d failed, the secret may be wrong: %w", user.ID, err)
+	}
+
+	return string(secret), nil
+}
+
+// EncryptTOTPSecrets encrypts the TOTP secrets stored before encryption was
+// added. Run it at startup, before requests are served.
+func EncryptTOTPSecrets(db *gorm.DB, logger *slog.Logger, secrets *encryption.Key) error {
+	var users []models.UserAccount
+	if err := db.Select("id", "totp_secret").Where("totp_secret IS NOT NULL AND totp_secret NOT LIKE ?", encryption.Prefix+"%").Find(&users).Error; err != nil {
+		return err
+	}
+
+	for _, user := range users {
+		encrypted, err := encryptTOTPSecret(secrets, user.ID, *user.TOTPSecret)
+		if err != nil {
+			return err
+		}
+
+		// another instance may have encrypted it in the meantime
+		if err := db.Model(&models.UserAccount{}).Where("id = ? AND totp_secret = ?", user.ID, *user.TOTPSecret).Update("totp_secret", encrypted).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(users) > 0 {
+		logger.Info("encrypted plaintext TOTP secrets", slog.Int("users", len(users)))
+	}
+
+	return nil
+}
+
+// verifyTOTP checks a code from the user's authenticator app. A code is
+// accepted only once, even within its time step.
+func verifyTOTP(db *gorm.DB, secrets *encryption.Key, user models.UserAccount, code string) (bool, error) {
+	if user.TOTPSecret == nil {
+		return false, nil
+	}
+
+	secret, err := decryptTOTPSecret(secrets, user)
+	if err != nil {
+		return false, err
+	}
+
+	counter, ok := totp.Validate(secret, code, time.Now(), totpSkew)
+	if !ok {
+		return false, nil
+	}
+
+	result := db.Model(&models.UserAccount{}).
+		Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+		Update("totp_last_counter", counter)
+
+	return result.RowsAffected == 1, result.Error
+}
+
+// verifySecondFactor accepts either a TOTP code or an unused recovery code,
+// which is used up.
+func verifySecondFactor(db *gorm.DB, secrets *encryption.Key, user models.UserAccount, code string) (bool, error) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+
+	if len(code) == totp.Digits {
+		return verifyTOTP(db, secrets, user, code)
+	}
+
+	result := db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", user.ID, hashToken(normalizeRecoveryCode(code))).
+		Update("used_at", time.Now())
+
+	return result.RowsAffected == 1, result.Error
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(code, "-", ""))
+}
+
+// generateRecoveryCodes replaces the user's recovery codes with new ones and
+// returns them in plain text, the only time they are shown.
+func generateRecoveryCodes(tx *gorm.DB, userId int64) ([]string, error) {
+	if err := tx.Where("user_id = ?", userId).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, 0, recoveryCodeCount)
+	now := time.Now()
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		bytes := make([]byte, 7)
+		if _, err := rand.Read(bytes); err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(bytes))[:10]
+		codes = append(codes, code[:5]+"-"+code[5:])
+		rows = append(rows, models.RecoveryCode{
+			UserID: userId,
+			CodeHash: hashToken(code),
+			CreatedAt: now,
+		})
+	}
+
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (h *TwoFactorHandler) findUser(c *gin.Context) (models.UserAccount, bool) {
+	var user models.UserAccount
+
+	if err := h.db.Where("id = ?", c.GetInt64(keys.UserID)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("user").Error()})
+			return user, false
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return user, false
+	}
+
+	return user, true
+}
+
+func bindTwoFactorCode(c *gin.Context) (models.TwoFactorCode, bool) {
+	var request models.TwoFactorCode
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return request, false
+	}
+
+	if err := validate.Struct(request); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return request, false
+	}
+
+	return request, true
+}
+
+// Enroll generates a new TOTP secret for the signed in user. Two-factor
+// authentication is only turned on once a code from it is confirmed.
+func (h *TwoFactorHandler) Enroll(c *gin.Context) {
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	encrypted, err := encryptTOTPSecret(h.secrets, user.ID, secret)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.db.Model(&models.UserAccount{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+		"totp_secret": encrypted,
+		"totp_last_counter": 0,
+	}).Error; err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	uri := totp.URI(h.issuer, user.Email, secret)
+
+	png, err := qrcode.Encode(uri, qrcode.Medium, 256)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret": secret,
+		"otpauthUri": uri,
+		"qrCode": "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)})
+}
+
+// Confirm turns two-factor authentication on with a code from the enrolled
+// secret and returns the recovery codes.
+func (h *TwoFactorHandler) Confirm(c *gin.Context) {
+	request, ok := bindTwoFactorCode(c)
+	if !ok {
+		return
+	}
+
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabledAt != nil {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{
+			"error": "two-factor authentication is already enabled"})
+		return
+	}
+
+	if user.TOTPSecret == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "two-factor authentication is not enrolled"})
+		return
+	}
+
+	valid, err := verifyTOTP(h.db, h.secrets, user, request.Code)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid code"})
+		return
+	}
+
+	var codes []string
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserAccount{}).Where("id = ?", user.ID).Update("totp_enabled_at", time.Now()).Error; err != nil {
+			return err
+		}
+
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recoveryCodes": codes})
+}
+
+// RegenerateRecoveryCodes replaces every recovery code, used or not.
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	request, ok := bindTwoFactorCode(c)
+	if !ok {
+		return
+	}
+
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "two-factor authentication is not enabled"})
+		return
+	}
+
+	valid, err := verifySecondFactor(h.db, h.secrets, user, request.Code)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid code"})
+		return
+	}
+
+	var codes []string
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		codes, err = generateRecoveryCodes(tx, user.ID)
+		return err
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"recoveryCodes": codes})
+}
+
+// Disable turns two-factor authentication off. It takes a current code, so
+// a stolen access token alone can't remove the second factor.
+func (h *TwoFactorHandler) Disable(c *gin.Context) {
+	request, ok := bindTwoFactorCode(c)
+	if !ok {
+		return
+	}
+
+	user, ok := h.findUser(c)
+	if !ok {
+		return
+	}
+
+	if user.TOTPEnabledAt == nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "two-factor authentication is not enabled"})
+		return
+	}
+
+	valid, err := verifySecondFactor(h.db, h.secrets, user, request.Code)
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if !valid {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid code"})
+		return
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserAccount{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"totp_secret": nil,
+			"totp_enabled_at": nil,
+			"totp_last_counter": 0,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := deleteTwoFactorChallenges(tx, user.ID); err != nil {
+			return err
+		}
+
+		return tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// SignInTwoFactor is the second step of signing in to an account with
+// two-factor authentication: it exchanges the challenge token returned by
+// SignInHandler and a TOTP or recovery code for the real tokens.
+func (h *AuthHandler) SignInTwoFactor(c *gin.Context) {
+	var request models.TwoFactorSignIn
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(request); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	var challenge models.TwoFactorChallenge
+	if err := h.db.Where("token_hash = ?", hashToken(request.ChallengeToken)).First(&challenge).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid or expired challenge"})
+			return
+		}
+
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	// counting the attempt up front keeps parallel guesses within the limit
+	result := h.db.Model(&models.TwoFactorChallenge{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?", challenge.ID, time.Now(), twoFactorChallengeAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": result.Error.Error()})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or expired challenge"})
+		return
+	}
+
+	var dbUser models.UserAccount
+	if err := h.db.Where("id = ?", challenge.UserID).First(&dbUser).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if dbUser.DisabledAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Account is disabled"})
+		return
+	}
+
+	if dbUser.PasswordResetRequired {
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": "Password has to be reset, check your email for the reset link"})
+		return
+	}
+
+	// wrong codes count against the same lockout as wrong passwords, so new
+	// challenges can't be used to keep guessing
+	accountKey := "account:" + strings.ToLower(dbUser.Email)
+	ipKey := "ip:" + c.ClientIP()
+
+	if !h.checkLockout(c, accountKey, ipKey) {
+		return
+	}
+
+	valid, err := verifySecondFactor(h.db, h.totpSecrets, dbUser, request.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if !valid || dbUser.TOTPEnabledAt == nil {
+		if h.recordFailure(c, accountKey, ipKey) {
+			c.JSON(http.StatusUnauthorized, gin.H{
+				"error": "Invalid code"})
+		}
+		return
+	}
+
+	result = h.db.Model(&models.TwoFactorChallenge{}).
+		Where("id = ? AND used_at IS NULL", challenge.ID).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": result.Error.Error()})
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error": "Invalid or expired challenge"})
+		return
+	}
+
+	if !h.resetLockout(c, accountKey, ipKey) {
+		return
+	}
+
+	h.completeSignIn(c, dbUser)
+}
@@ -0,0 +1,156 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/encryption"
+	"github.com/noctispine/blog/pkg/totp"
+)
+
+func TestTOTPSecretEncryption(t *testing.T) {
+	secrets := testTOTPSecrets(t)
+
+	encrypted, err := encryptTOTPSecret(secrets, 1, testTOTPSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !encryption.IsEncrypted(encrypted) {
+		t.Fatalf("stored secret = %q, want it encrypted", encrypted)
+	}
+
+	plaintext := testTOTPSecret
+
+	tests := []struct {
+		name string
+		user models.UserAccount
+		wantErr bool
+	}{
+		{"own account", models.UserAccount{ID: 1, TOTPSecret: &encrypted}, false},
+		{"copied to another account", models.UserAccount{ID: 2, TOTPSecret: &encrypted}, true},
+		{"stored before encryption", models.UserAccount{ID: 1, TOTPSecret: &plaintext}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret, err := decryptTOTPSecret(secrets, tt.user)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && secret != testTOTPSecret {
+				t.Errorf("secret = %q, want %q", secret, testTOTPSecret)
+			}
+		})
+	}
+}
+
+func TestEncryptTOTPSecrets(t *testing.T) {
+	db := testDB(t)
+	secrets := testTOTPSecrets(t)
+	user := createTestUser(t, db, uniqueEmail(t), false)
+
+	if err := db.Model(&user).Update("totp_secret", testTOTPSecret).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err := EncryptTOTPSecrets(db, testLogger(), secrets); err != nil {
+		t.Fatal(err)
+	}
+
+	var stored models.UserAccount
+	if err := db.Where("id = ?", user.ID).First(&stored).Error; err != nil {
+		t.Fatal(err)
+	}
+	if stored.TOTPSecret == nil || !encryption.IsEncrypted(*stored.TOTPSecret) {
+		t.Fatalf("totp_secret = %v, want it encrypted", stored.TOTPSecret)
+	}
+
+	code, err := totp.Code(testTOTPSecret, totp.Counter(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if valid, err := verifyTOTP(db, secrets, stored, code); err != nil || !valid {
+		t.Errorf("verifyTOTP = %v, %v, want the code accepted", valid, err)
+	}
+}
+
+func TestSignInTwoFactorAfterAccountLocked(t *testing.T) {
+	db := testDB(t)
+	mailer := &testMailer{}
+	auth := newTestAuthHandler(t, db, mailer)
+	users := NewUserHandler(context.Background(), db, testLogger(), auth.revocations, mailer, testBaseURL)
+	admin := createTestUser(t, db, uniqueEmail(t), false)
+
+	tests := []struct {
+		name string
+		// locks the account after the password check passed
+		lock func(t *testing.T, user models.UserAccount)
+		want int
+	}{
+		{
+			name: "not locked",
+			lock: func(t *testing.T, user models.UserAccount) {},
+			want: http.StatusOK,
+		},
+		{
+			name: "password reset forced",
+			lock: func(t *testing.T, user models.UserAccount) {
+				target := "/admin/users/" + strconv.FormatInt(user.ID, 10) + "/password-reset"
+				if recorder := serve(users.ForcePasswordReset, http.MethodPost, "/admin/users/:id/password-reset", target, admin.ID, nil); recorder.Code != http.StatusAccepted {
+					t.Fatalf("forcing the reset answered %d", recorder.Code)
+				}
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			name: "disabled",
+			lock: func(t *testing.T, user models.UserAccount) {
+				target := "/admin/users/" + strconv.FormatInt(user.ID, 10) + "/disable"
+				if recorder := serve(users.Disable, http.MethodPost, "/admin/users/:id/disable", target, admin.ID, models.DisableUser{}); recorder.Code != http.StatusNoContent {
+					t.Fatalf("disabling answered %d", recorder.Code)
+				}
+			},
+			want: http.StatusUnauthorized,
+		},
+		{
+			// the challenge survives, the account check still refuses it
+			name: "reset flagged directly",
+			lock: func(t *testing.T, user models.UserAccount) {
+				if err := db.Model(&models.UserAccount{}).Where("id = ?", user.ID).Update("password_reset_required", true).Error; err != nil {
+					t.Fatal(err)
+				}
+			},
+			want: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := createTestUser(t, db, uniqueEmail(t), true)
+
+			challenge, _, err := createTwoFactorChallenge(db, user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			tt.lock(t, user)
+
+			code, err := totp.Code(testTOTPSecret, totp.Counter(time.Now()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			recorder := serve(auth.SignInTwoFactor, http.MethodPost, "/user/signin/2fa", "/user/signin/2fa", 0, map[string]string{
+				"challengeToken": challenge,
+				"code": code,
+			})
+			if recorder.Code != tt.want {
+				t.Errorf("answered %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgerrcode"
+	"github.com/noctispine/blog/cmd/constants/audit"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/mail"
+	"github.com/noctispine/blog/pkg/pagination"
+	"github.com/noctispine/blog/pkg/revocation"
+	"github.com/noctispine/blog/pkg/scopes"
+	"github.com/noctispine/blog/pkg/utils"
+	"github.com/noctispine/blog/pkg/wrappers"
+	"gorm.io/gorm"
+)
+
+// UserHandler is the admin side of user accounts. Every change is recorded
+// in the audit log together with the admin who made it.
+type UserHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+	revocations *revocation.Store
+	mailer mail.Mailer
+	baseURL string
+}
+
+func NewUserHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, revocations *revocation.Store, mailer mail.Mailer, baseURL string) *UserHandler {
+	return &UserHandler{
+		ctx,
+		db,
+		logger,
+		revocations,
+		mailer,
+		strings.TrimSuffix(baseURL, "/"),
+	}
+}
+
+// recordAudit logs an admin action on the target user.
+func recordAudit(tx *gorm.DB, c *gin.Context, target models.UserAccount, action string, details string) error {
+	actorId := c.GetInt64(keys.UserID)
+	targetId := target.ID
+
+	return tx.Create(&models.AdminAuditLog{
+		ActorID: &actorId,
+		TargetUserID: &targetId,
+		TargetEmail: target.Email,
+		Action: action,
+		Details: details,
+		CreatedAt: time.Now(),
+	}).Error
+}
+
+func userSummary(user models.UserAccount) models.UserSummary {
+	return models.UserSummary{
+		ID: user.ID,
+		Email: user.Email,
+		FirstName: user.FirstName,
+		LastName: user.LastName,
+		Role: user.Role,
+		RegisteredAt: user.RegisteredAt,
+		LastLoginAt: user.LastLoginAt,
+		VerifiedAt: user.VerifiedAt,
+		TwoFactorEnabled: user.TOTPEnabledAt != nil,
+		DisabledAt: user.DisabledAt,
+		DisabledReason: user.DisabledReason,
+		PasswordResetRequired: user.PasswordResetRequired,
+	}
+}
+
+// findTarget loads the user from the :id param. Admins can't act on their
+// own account here, so they can't lock themselves out by accident.
+func (h *UserHandler) findTarget(c *gin.Context, allowSelf bool) (models.UserAccount, bool) {
+	var user models.UserAccount
+
+	userId, err := strconv.ParseInt(c.Params.ByName("id"), 10, 64)
+	if err != nil {
+		c.AbortWithStatus(http.StatusBadRequest)
+		return user, false
+	}
+
+	if !allowSelf && userId == c.GetInt64(keys.UserID) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "you can't do this to your own account"})
+		return user, false
+	}
+
+	if err := h.db.Where("id = ?", userId).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("user").Error()})
+			return user, false
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return user, false
+	}
+
+	return user, true
+}
+
+// GetPage lists users, optionally filtered by ?search= on the email and name.
+func (h *UserHandler) GetPage(c *gin.Context) {
+	var users []models.UserAccount
+	var pagination pagination.Pagination
+
+	query := h.db.Model(&models.UserAccount{})
+
+	if search := strings.TrimSpace(c.Query("search")); search != "" {
+		pattern := "%" + strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(search) + "%"
+		query = query.Where("email ILIKE ? OR first_name ILIKE ? OR last_name ILIKE ? OR first_name || ' ' || last_name ILIKE ?", pattern, pattern, pattern, pattern)
+	}
+
+	query = query.Session(&gorm.Session{})
+	result := query.Scopes(scopes.Paginate(models.UserAccount{}, &pagination, query, c)).Find(&users)
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	summaries := make([]models.UserSummary, 0, len(users))
+	for _, user := range users {
+		summaries = append(summaries, userSummary(user))
+	}
+
+	pagination.Rows = summaries
+	c.JSON(http.StatusOK, pagination)
+}
+
+func (h *UserHandler) Get(c *gin.Context) {
+	user, ok := h.findTarget(c, true)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, userSummary(user))
+}
+
+// ChangeRole moves the user to another role. Their access tokens are revoked
+// so the new permissions apply from the next refresh.
+func (h *UserHandler) ChangeRole(c *gin.Context) {
+	var request models.ChangeUserRole
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(request); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	user, ok := h.findTarget(c, false)
+	if !ok {
+		return
+	}
+
+	revoked := h.revocations.Pending()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserAccount{}).Where("id = ?", user.ID).Update("role", *request.RoleID).Error; err != nil {
+			return err
+		}
+
+		if err := revoked.RevokeUser(tx, user.ID); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, user, audit.ROLE_CHANGED, fmt.Sprintf("role %d -> %d", user.Role, *request.RoleID))
+	})
+
+	if err != nil {
+		if utils.CheckPostgreError(err, pgerrcode.ForeignKeyViolation) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": wrappers.NewErrDoesNotExist("role").Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	revoked.Apply()
+
+	c.Status(http.StatusNoContent)
+}
+
+// Disable blocks the account from signing in and ends every session and
+// personal access token use until it is enabled again.
+func (h *UserHandler) Disable(c *gin.Context) {
+	var request models.DisableUser
+
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	if err := validate.Struct(request); err != nil {
+		errs := translateError(err, enTrans)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"errors": stringfyJSONErrArr(errs)})
+		return
+	}
+
+	user, ok := h.findTarget(c, false)
+	if !ok {
+		return
+	}
+
+	revoked := h.revocations.Pending()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserAccount{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"disabled_at": time.Now(),
+			"disabled_reason": request.Reason,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := revokeUserRefreshTokens(tx, user.ID); err != nil {
+			return err
+		}
+
+		if err := deleteTwoFactorChallenges(tx, user.ID); err != nil {
+			return err
+		}
+
+		if err := revoked.RevokeUser(tx, user.ID); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, user, audit.USER_DISABLED, request.Reason)
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	revoked.Apply()
+
+	c.Status(http.StatusNoContent)
+}
+
+func (h *UserHandler) Enable(c *gin.Context) {
+	user, ok := h.findTarget(c, false)
+	if !ok {
+		return
+	}
+
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserAccount{}).Where("id = ?", user.ID).Updates(map[string]interface{}{
+			"disabled_at": nil,
+			"disabled_reason": "",
+		}).Error; err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, user, audit.USER_ENABLED, "")
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// ForcePasswordReset signs the user out everywhere, personal access tokens
+// included, and mails them a reset link. Signing in with the old password
+// is refused until they reset it.
+func (h *UserHandler) ForcePasswordReset(c *gin.Context) {
+	user, ok := h.findTarget(c, false)
+	if !ok {
+		return
+	}
+
+	revoked := h.revocations.Pending()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.UserAccount{}).Where("id = ?", user.ID).Update("password_reset_required", true).Error; err != nil {
+			return err
+		}
+
+		if err := revokeUserRefreshTokens(tx, user.ID); err != nil {
+			return err
+		}
+
+		if err := deleteTwoFactorChallenges(tx, user.ID); err != nil {
+			return err
+		}
+
+		if err := revokeUserPersonalAccessTokens(tx, user.ID); err != nil {
+			return err
+		}
+
+		if err := revoked.RevokeUser(tx, user.ID); err != nil {
+			return err
+		}
+
+		return recordAudit(tx, c, user, audit.PASSWORD_RESET_FORCED, "")
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	revoked.Apply()
+
+	if err := sendPasswordReset(c, h.db, h.logger, h.mailer, h.baseURL, user); err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Status(http.StatusAccepted)
+}
+
+// Delete removes the account with everything it owns.
+func (h *UserHandler) Delete(c *gin.Context) {
+	user, ok := h.findTarget(c, false)
+	if !ok {
+		return
+	}
+
+	revoked := h.revocations.Pending()
+	err := h.db.Transaction(func(tx *gorm.DB) error {
+		if err := recordAudit(tx, c, user, audit.USER_DELETED, ""); err != nil {
+			return err
+		}
+
+		if err := revoked.RevokeUser(tx, user.ID); err != nil {
+			return err
+		}
+
+		return tx.Delete(&models.UserAccount{}, user.ID).Error
+	})
+
+	if err != nil {
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	revoked.Apply()
+
+	c.Status(http.StatusNoContent)
+}
+
+// AuditLog lists admin actions, newest first, optionally only those by
+// ?actorId= or on ?userId=.
+func (h *UserHandler) AuditLog(c *gin.Context) {
+	var entries []models.AdminAuditLog
+	var pagination pagination.Pagination
+
+	query := h.db.Model(&models.AdminAuditLog{})
+
+	if actorId, ok := c.GetQuery("actorId"); ok {
+		query = query.Where("actor_id = ?", actorId)
+	}
+
+	if userId, ok := c.GetQuery("userId"); ok {
+		query = query.Where("target_user_id = ?", userId)
+	}
+
+	query = query.Session(&gorm.Session{})
+	result := query.Scopes(scopes.Paginate(models.AdminAuditLog{}, &pagination, query, c)).Find(&entries)
+	if result.RowsAffected == 0 {
+		c.Status(http.StatusNoContent)
+		return
+	}
+
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	pagination.Rows = entries
+	c.JSON(http.StatusOK, pagination)
+}
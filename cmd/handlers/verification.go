@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/mail"
+	"github.com/noctispine/blog/pkg/wrappers"
+	"gorm.io/gorm"
+)
+
+const verificationLifetime = 48 * time.Hour
+
+// minimum time between two verification emails to the same account
+const verificationResendInterval = time.Minute
+
+var errInvalidVerificationToken = errors.New("verification link is invalid or expired")
+
+type VerificationHandler struct {
+	ctx context.Context
+	db *gorm.DB
+	logger *slog.Logger
+	mailer mail.Mailer
+	baseURL string
+	secret string
+}
+
+func NewVerificationHandler(ctx context.Context, db *gorm.DB, logger *slog.Logger, mailer mail.Mailer, baseURL string, secret string) *VerificationHandler {
+	return &VerificationHandler{
+		ctx,
+		db,
+		logger,
+		mailer,
+		strings.TrimSuffix(baseURL, "/"),
+		secret,
+	}
+}
+
+// verificationSignature binds the user, the expiry and the email address,
+// so a link stops working once the account's email changes.
+func verificationSignature(secret string, payload string, email string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(payload + "." + strings.ToLower(email)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func signVerificationToken(secret string, user models.UserAccount, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", user.ID, expires.Unix())))
+	return payload + "." + verificationSignature(secret, payload, user.Email)
+}
+
+// parseVerificationToken returns the user id a token was issued for. The
+// signature still has to be checked against that user's email.
+func parseVerificationToken(token string) (int64, string, error) {
+	payload, _, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, "", errInvalidVerificationToken
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return 0, "", errInvalidVerificationToken
+	}
+
+	rawUserId, rawExpires, ok := strings.Cut(string(decoded), ".")
+	if !ok {
+		return 0, "", errInvalidVerificationToken
+	}
+
+	userId, err := strconv.ParseInt(rawUserId, 10, 64)
+	if err != nil {
+		return 0, "", errInvalidVerificationToken
+	}
+
+	expires, err := strconv.ParseInt(rawExpires, 10, 64)
+	if err != nil || time.Now().After(time.Unix(expires, 0)) {
+		return 0, "", errInvalidVerificationToken
+	}
+
+	return userId, payload, nil
+}
+
+// sendVerification mails a verification link to the user in the background.
+func sendVerification(c *gin.Context, logger *slog.Logger, mailer mail.Mailer, baseURL string, secret string, user models.UserAccount) {
+	token := signVerificationToken(secret, user, time.Now().Add(verificationLifetime))
+	link := fmt.Sprintf("%s/user/verify?token=%s", baseURL, url.QueryEscape(token))
+	ctx := c.Request.Context()
+
+	go func() {
+		if err := mailer.Send(context.Background(), mail.Message{
+			To: user.Email,
+			Subject: "Verify your email address",
+			Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below. It expires in %d hours.\n\n%s\n",
+				user.FirstName, int(verificationLifetime.Hours()), link),
+		}); err != nil {
+			logger.ErrorContext(ctx, "sending verification email failed", slog.Any("error", err))
+		}
+	}()
+}
+
+func (h *VerificationHandler) Verify(c *gin.Context) {
+	userId, payload, err := parseVerificationToken(c.Query("token"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": err.Error()})
+		return
+	}
+
+	var user models.UserAccount
+	if err := h.db.Where("id = ?", userId).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+				"error": errInvalidVerificationToken.Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	_, signature, _ := strings.Cut(c.Query("token"), ".")
+	if !hmac.Equal([]byte(signature), []byte(verificationSignature(h.secret, payload, user.Email))) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": errInvalidVerificationToken.Error()})
+		return
+	}
+
+	if user.VerifiedAt == nil {
+		if err := h.db.Model(&models.UserAccount{}).Where("id = ? AND verified_at IS NULL", user.ID).Update("verified_at", time.Now()).Error; err != nil {
+			logError(c, h.logger, err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "email verified"})
+}
+
+// Resend mails a new verification link to the signed in user, at most once
+// per verificationResendInterval.
+func (h *VerificationHandler) Resend(c *gin.Context) {
+	var user models.UserAccount
+	if err := h.db.Where("id = ?", c.GetInt64(keys.UserID)).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{
+				"error": wrappers.NewErrNotFound("user").Error()})
+			return
+		}
+
+		logError(c, h.logger, err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if user.VerifiedAt != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{
+			"error": "email is already verified"})
+		return
+	}
+
+	// the conditional update makes concurrent resends count as one
+	now := time.Now()
+	result := h.db.Model(&models.UserAccount{}).
+		Where("id = ? AND (verification_sent_at IS NULL OR verification_sent_at <= ?)", user.ID, now.Add(-verificationResendInterval)).
+		Update("verification_sent_at", now)
+	if result.Error != nil {
+		logError(c, h.logger, result.Error)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	if result.RowsAffected == 0 {
+		wait := verificationResendInterval
+		if user.VerificationSentAt != nil {
+			wait -= time.Since(*user.VerificationSentAt)
+		}
+
+		c.Header("Retry-After", strconv.Itoa(int(math.Max(1, math.Ceil(wait.Seconds())))))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{
+			"error": "a verification email was sent recently, try again later"})
+		return
+	}
+
+	sendVerification(c, h.logger, h.mailer, h.baseURL, h.secret, user)
+	c.Status(http.StatusAccepted)
+}
@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/noctispine/blog/cmd/models"
+)
+
+const testVerificationSecret = "verification-test-secret"
+
+func TestVerifySignatureBinding(t *testing.T) {
+	db := testDB(t)
+	h := NewVerificationHandler(context.Background(), db, testLogger(), &testMailer{}, testBaseURL, testVerificationSecret)
+
+	tests := []struct {
+		name string
+		// returns the token presented for the user
+		token func(t *testing.T, user models.UserAccount) string
+		want int
+	}{
+		{
+			name: "issued for the user",
+			token: func(t *testing.T, user models.UserAccount) string {
+				return signVerificationToken(testVerificationSecret, user, time.Now().Add(time.Hour))
+			},
+			want: http.StatusOK,
+		},
+		{
+			name: "signed with another secret",
+			token: func(t *testing.T, user models.UserAccount) string {
+				return signVerificationToken("another-secret", user, time.Now().Add(time.Hour))
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "issued for another user",
+			token: func(t *testing.T, user models.UserAccount) string {
+				other := createTestUser(t, db, uniqueEmail(t), false)
+				signed := signVerificationToken(testVerificationSecret, other, time.Now().Add(time.Hour))
+
+				// keep the other user's signature, claim this user's id
+				_, signature, _ := strings.Cut(signed, ".")
+				payload := base64.RawURLEncoding.EncodeToString([]byte(fmt.Sprintf("%d.%d", user.ID, time.Now().Add(time.Hour).Unix())))
+				return payload + "." + signature
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "email changed since",
+			token: func(t *testing.T, user models.UserAccount) string {
+				token := signVerificationToken(testVerificationSecret, user, time.Now().Add(time.Hour))
+				if err := db.Model(&models.UserAccount{}).Where("id = ?", user.ID).Update("email", uniqueEmail(t)).Error; err != nil {
+					t.Fatal(err)
+				}
+				return token
+			},
+			want: http.StatusBadRequest,
+		},
+		{
+			name: "expired",
+			token: func(t *testing.T, user models.UserAccount) string {
+				return signVerificationToken(testVerificationSecret, user, time.Now().Add(-time.Minute))
+			},
+			want: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := createTestUser(t, db, uniqueEmail(t), false)
+			target := "/user/verify?token=" + url.QueryEscape(tt.token(t, user))
+
+			recorder := serve(h.Verify, http.MethodGet, "/user/verify", target, 0, nil)
+			if recorder.Code != tt.want {
+				t.Fatalf("answered %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+
+			var stored models.UserAccount
+			if err := db.Select("verified_at").Where("id = ?", user.ID).First(&stored).Error; err != nil {
+				t.Fatal(err)
+			}
+			if verified := stored.VerifiedAt != nil; verified != (tt.want == http.StatusOK) {
+				t.Errorf("verified = %v after answering %d", verified, recorder.Code)
+			}
+		})
+	}
+}
@@ -2,109 +2,437 @@ package main
 
 import (
 	"context"
+	"errors"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/joho/godotenv"
-	"github.com/noctispine/blog/cmd/constants/roles"
+	"github.com/noctispine/blog/cmd/config"
+	"github.com/noctispine/blog/cmd/constants/permissions"
+	"github.com/noctispine/blog/cmd/constants/scopes"
 	dbPackage "github.com/noctispine/blog/cmd/db"
 	"github.com/noctispine/blog/cmd/handlers"
+	"github.com/noctispine/blog/cmd/workers"
+	"github.com/noctispine/blog/pkg/encryption"
+	"github.com/noctispine/blog/pkg/feeds"
+	"github.com/noctispine/blog/pkg/lockout"
+	"github.com/noctispine/blog/pkg/logging"
+	"github.com/noctispine/blog/pkg/mail"
 	"github.com/noctispine/blog/pkg/middlewares"
+	"github.com/noctispine/blog/pkg/passwords"
+	"github.com/noctispine/blog/pkg/revocation"
+	"github.com/noctispine/blog/pkg/signing"
 	"gorm.io/gorm"
 )
 
+var cfg *config.Config
+var logger *slog.Logger
 var db *gorm.DB
 var authHandler *handlers.AuthHandler
 var postHandler *handlers.PostHandler
 var categoryHandler *handlers.CategoryHandler
 var tagHandler *handlers.TagHandler
 var postCategoryHandler *handlers.PostCategoryHandler
+var commentHandler *handlers.CommentHandler
+var moderationHandler *handlers.ModerationHandler
+var revisionHandler *handlers.RevisionHandler
+var searchHandler *handlers.SearchHandler
+var feedHandler *handlers.FeedHandler
+var sitemapHandler *handlers.SitemapHandler
+var postTagHandler *handlers.PostTagHandler
+var publisher *workers.Publisher
+var revocations *revocation.Store
+var keyRing *signing.KeyRing
+var totpSecrets *encryption.Key
+var mailer mail.Mailer
+var passwordHandler *handlers.PasswordHandler
+var verificationHandler *handlers.VerificationHandler
+var twoFactorHandler *handlers.TwoFactorHandler
+var personalAccessTokenHandler *handlers.PersonalAccessTokenHandler
+var roleHandler *handlers.RoleHandler
+var userHandler *handlers.UserHandler
+var oidcHandler *handlers.OIDCHandler
+var healthHandler *handlers.HealthHandler
 var ctx context.Context
+var stopWorkers context.CancelFunc
 
 
 func init() {
-	err := godotenv.Load(".env")
+	var err error
+	if cfg, err = config.Load(); err != nil {
+		log.Fatal(err)
+	}
+
+	if logger, err = logging.New(os.Stdout, cfg.Log.Level, cfg.Log.Format); err != nil {
+		log.Fatal(err)
+	}
+	// the log package writes through the logger as well
+	slog.SetDefault(logger)
+
+	ctx, stopWorkers = context.WithCancel(context.Background())
+	db = dbPackage.GetDatabase(cfg.Database, logger)
+	publisher = workers.NewPublisher(db, logger)
+	revocations = revocation.NewStore(db, logger)
+	keyRing = newKeyRing()
+	mailer = newMailer()
+	accountLimiter, ipLimiter := newLimiter(cfg.SignIn.MaxAttempts), newLimiter(cfg.SignIn.IPMaxAttempts)
+	hasher := newPasswordHasher()
+	totpSecrets = newTOTPSecrets()
+	authHandler = handlers.NewAuthHandler(ctx, db, logger, revocations, mailer, cfg.Server.PublicURL, accountLimiter, ipLimiter, hasher, keyRing, totpSecrets, cfg.JWT)
+	postHandler = handlers.NewPostHandler(ctx, db, logger, publisher)
+	categoryHandler = handlers.NewCategoryHandler(ctx, db, logger)
+	tagHandler = handlers.NewTagHandler(ctx, db, logger)
+	postCategoryHandler = handlers.NewPostCategoryHandler(ctx, db, logger)
+	postTagHandler = handlers.NewPostTagHandler(ctx, db, logger)
+	commentHandler = handlers.NewCommentHandler(ctx, db, logger)
+	moderationHandler = handlers.NewModerationHandler(ctx, db, logger)
+	revisionHandler = handlers.NewRevisionHandler(ctx, db, logger)
+	searchHandler = handlers.NewSearchHandler(ctx, db, logger, cfg.Server.SearchLanguage)
+	feedHandler = handlers.NewFeedHandler(ctx, db, logger, cfg.Server.PublicURL, cfg.Server.BlogTitle)
+	sitemapHandler = handlers.NewSitemapHandler(ctx, db, logger, cfg.Server.PublicURL)
+	passwordHandler = handlers.NewPasswordHandler(ctx, db, logger, revocations, mailer, cfg.Server.PublicURL, hasher, newLimiter(cfg.SignIn.ResetMaxRequests), newLimiter(cfg.SignIn.ResetIPMaxRequests))
+	verificationHandler = handlers.NewVerificationHandler(ctx, db, logger, mailer, cfg.Server.PublicURL, cfg.JWT.Secret)
+	twoFactorHandler = handlers.NewTwoFactorHandler(ctx, db, logger, cfg.Server.BlogTitle, totpSecrets)
+	personalAccessTokenHandler = handlers.NewPersonalAccessTokenHandler(ctx, db, logger)
+	roleHandler = handlers.NewRoleHandler(ctx, db, logger, revocations)
+	userHandler = handlers.NewUserHandler(ctx, db, logger, revocations, mailer, cfg.Server.PublicURL)
+	healthHandler = handlers.NewHealthHandler(ctx, db, logger, map[string]handlers.HealthReporter{
+		"publisher": publisher,
+		"revocations": revocations,
+		"signingKeys": keyRing,
+	})
+
+	if cfg.OIDC.IssuerURL != "" {
+		redirectURL := cfg.OIDC.RedirectURL
+		if redirectURL == "" {
+			redirectURL = strings.TrimSuffix(cfg.Server.PublicURL, "/") + "/user/oidc/callback"
+		}
+
+		oidcHandler = handlers.NewOIDCHandler(ctx, db, logger, authHandler, handlers.OIDCConfig{
+			IssuerURL: cfg.OIDC.IssuerURL,
+			ClientID: cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL: redirectURL,
+			Scopes: cfg.OIDC.Scopes,
+		})
+	}
+}
+
+
+
+// newMailer picks the mail transport from MAIL_DRIVER: "smtp" sends through
+// SMTP_HOST, anything else writes messages to MAIL_OUTBOX_DIR.
+func newMailer() mail.Mailer {
+	if cfg.Mail.Driver == "smtp" {
+		return mail.NewSMTPMailer(cfg.Mail.SMTPHost, cfg.Mail.SMTPPort, cfg.Mail.SMTPUser, cfg.Mail.SMTPPassword, cfg.Mail.From)
+	}
+
+	return mail.NewOutboxMailer(cfg.Mail.OutboxDir, cfg.Mail.From)
+}
+
+// newTOTPSecrets encrypts the TOTP secrets stored in the database with
+// TOTP_ENCRYPTION_SECRET.
+func newTOTPSecrets() *encryption.Key {
+	key, err := encryption.NewKey(cfg.TwoFactor.EncryptionSecret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return key
+}
+
+// newKeyRing signs access tokens with JWT_SIGNING_ALGORITHM, RS256 by
+// default or EdDSA, and rotates the key every JWT_KEY_ROTATION_HOURS. Old keys
+// are kept until the last token they signed has expired.
+func newKeyRing() *signing.KeyRing {
+	rotateEvery := time.Duration(cfg.JWT.KeyRotationHours) * time.Hour
+	retainFor := time.Duration(cfg.JWT.ExpireMinutes)*time.Minute + time.Hour
+
+	keys, err := signing.NewKeyRing(db, logger, cfg.JWT.SigningAlgorithm, rotateEvery, retainFor, cfg.JWT.SigningKeySecret)
 	if err != nil {
-		log.Fatal("Error loading .env file")
+		log.Fatal(err)
+	}
+	return keys
+}
+
+// newLimiter builds a lockout limiter allowing threshold attempts, used for
+// failed sign ins and password reset requests per account and client IP.
+// SIGNIN_LIMITER=postgres shares the counters between instances.
+func newLimiter(threshold int) lockout.Limiter {
+	policy := lockout.Policy{
+		Threshold: threshold,
+		BaseDelay: time.Duration(cfg.SignIn.LockoutSeconds) * time.Second,
+		MaxDelay: time.Duration(cfg.SignIn.LockoutMaxSeconds) * time.Second,
+		Window: time.Duration(cfg.SignIn.FailureWindowSeconds) * time.Second,
 	}
 
-	db = dbPackage.GetDatabase()
-	authHandler = handlers.NewAuthHandler(ctx, db)
-	postHandler = handlers.NewPostHandler(ctx, db)
-	categoryHandler = handlers.NewCategoryHandler(ctx, db)
-	tagHandler = handlers.NewTagHandler(ctx, db)
-	postCategoryHandler = handlers.NewPostCategoryHandler(ctx, db)
+	if cfg.SignIn.Limiter == "postgres" {
+		return lockout.NewPostgresLimiter(db, logger, policy)
+	}
+
+	return lockout.NewMemoryLimiter(policy)
 }
 
+// newPasswordHasher hashes new passwords with PASSWORD_HASHER, argon2id by
+// default or bcrypt, and still verifies hashes of the other one. Those are
+// replaced on the next successful sign in.
+func newPasswordHasher() passwords.Hasher {
+	bcryptHasher := passwords.NewBcrypt(cfg.Passwords.BcryptCost)
+	argon2Hasher := passwords.NewArgon2id(
+		uint32(cfg.Passwords.Argon2MemoryKiB),
+		uint32(cfg.Passwords.Argon2Iterations),
+		uint8(cfg.Passwords.Argon2Parallelism),
+	)
 
+	if cfg.Passwords.Hasher == "bcrypt" {
+		return passwords.NewChain(bcryptHasher, argon2Hasher)
+	}
+
+	return passwords.NewChain(argon2Hasher, bcryptHasher)
+}
 
 func main() {
-	r := gin.Default()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(os.Args[2:])
+		return
+	}
+
+	if err := dbPackage.CheckSchema(db); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := dbPackage.SyncSearchLanguage(db, logger, cfg.Server.SearchLanguage); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := handlers.EncryptTOTPSecrets(db, logger, totpSecrets); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := revocations.Load(); err != nil {
+		log.Fatal(err)
+	}
+
+	if err := keyRing.Load(); err != nil {
+		log.Fatal(err)
+	}
+
+	go publisher.Run(ctx)
+	go revocations.Run(ctx)
+	go keyRing.Run(ctx)
+
+	r := gin.New()
+	// gin trusts every proxy by default, which lets clients pick their IP
+	// for the sign in limiter with X-Forwarded-For
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatal(err)
+	}
+	r.Use(middlewares.RequestLogger(logger), gin.Recovery())
+	r.GET("/healthz", healthHandler.Live)
+	r.GET("/readyz", healthHandler.Ready)
+	r.GET("/.well-known/jwks.json", authHandler.JWKS)
 	user := r.Group("/user")
 	{
 		user.POST("/sign-in", authHandler.SignInHandler)
+		user.POST("/sign-in/2fa", authHandler.SignInTwoFactor)
 		user.POST("/register", authHandler.Register)
-		user.POST("/refresh/:id", authHandler.RefreshHandler)
+		user.POST("/refresh", authHandler.RefreshHandler)
+		user.POST("/logout", authHandler.Logout)
+		user.POST("/logout-all", middlewares.ValidateToken(db, logger, revocations, keyRing), middlewares.SessionOnly(), authHandler.LogoutAll)
+		user.POST("/password/forgot", passwordHandler.Forgot)
+		user.POST("/password/reset", passwordHandler.Reset)
+		user.GET("/verify", verificationHandler.Verify)
+		user.POST("/verify/resend", middlewares.ValidateToken(db, logger, revocations, keyRing), middlewares.SessionOnly(), verificationHandler.Resend)
+	}
+
+	if oidcHandler != nil {
+		user.GET("/oidc/login", oidcHandler.Login)
+		user.GET("/oidc/callback", oidcHandler.Callback)
+	}
+
+	personalAccessTokens := r.Group("/user/tokens", middlewares.ValidateToken(db, logger, revocations, keyRing), middlewares.SessionOnly())
+	{
+		personalAccessTokens.GET("", personalAccessTokenHandler.GetAll)
+		personalAccessTokens.POST("", personalAccessTokenHandler.Create)
+		personalAccessTokens.DELETE(":id", personalAccessTokenHandler.Delete)
+	}
+
+	twoFactor := r.Group("/user/2fa", middlewares.ValidateToken(db, logger, revocations, keyRing), middlewares.SessionOnly())
+	{
+		twoFactor.POST("/enroll", twoFactorHandler.Enroll)
+		twoFactor.POST("/confirm", twoFactorHandler.Confirm)
+		twoFactor.POST("/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
+		twoFactor.POST("/disable", twoFactorHandler.Disable)
 	}
 
 	posts := r.Group("/posts")
 	{
 		posts.GET("/all", postHandler.GetAll)
 		posts.GET("", middlewares.Pagination(), postHandler.GetPage)
+		posts.GET("/search", middlewares.Pagination(), searchHandler.SearchPosts)
 		posts.GET(":id", middlewares.Pagination(), postHandler.GetPageByCategory)
+		posts.GET(":id/comments", middlewares.Pagination(), commentHandler.GetPage)
 	}
 
 	categories := r.Group("/categories")
 	{
 		categories.GET("", categoryHandler.GetAll)
+		categories.GET(":slug/feed.rss", feedHandler.CategoryFeed(feeds.RSS))
+		categories.GET(":slug/feed.atom", feedHandler.CategoryFeed(feeds.Atom))
+		categories.GET(":slug/feed.json", feedHandler.CategoryFeed(feeds.JSON))
 	}
 
 	tags := r.Group("/tags")
 	{
 		tags.GET("", tagHandler.GetAll)
+		tags.GET(":slug/posts", middlewares.Pagination(), postHandler.GetPageByTag)
+		tags.GET(":slug/feed.rss", feedHandler.TagFeed(feeds.RSS))
+		tags.GET(":slug/feed.atom", feedHandler.TagFeed(feeds.Atom))
+		tags.GET(":slug/feed.json", feedHandler.TagFeed(feeds.JSON))
 	}
 
+	r.GET("/feed.rss", feedHandler.SiteFeed(feeds.RSS))
+	r.GET("/feed.atom", feedHandler.SiteFeed(feeds.Atom))
+	r.GET("/feed.json", feedHandler.SiteFeed(feeds.JSON))
+	r.GET("/sitemap.xml", sitemapHandler.Sitemap)
+	r.GET("/sitemaps/:file", sitemapHandler.Page)
+
 	
 
-	blogger := r.Group("/", middlewares.ValidateToken(), middlewares.Authorization(roles.BLOGGER_PERMS))
+	blogger := r.Group("/", middlewares.ValidateToken(db, logger, revocations, keyRing), middlewares.RequireVerifiedEmail(db))
 	{
-		bloggerPost := blogger.Group("posts")
+		bloggerPost := blogger.Group("posts", middlewares.RequireScope(scopes.POSTS_WRITE))
 		{
-			bloggerPost.POST("", postHandler.Create)
-			bloggerPost.PATCH("", postHandler.Update)
-			bloggerPost.DELETE(":id", postHandler.Delete)
-			bloggerPost.PATCH(":id", postHandler.TogglePublish)
+			bloggerPost.POST("", middlewares.RequirePermission(permissions.POST_CREATE), postHandler.Create)
+			bloggerPost.PATCH("", middlewares.RequirePermission(permissions.POST_CREATE), postHandler.Update)
+			bloggerPost.DELETE(":id", middlewares.RequirePermission(permissions.POST_CREATE), postHandler.Delete)
+			bloggerPost.PATCH(":id", middlewares.RequirePermission(permissions.POST_PUBLISH), postHandler.TogglePublish)
+			bloggerPost.PUT(":id/schedule", middlewares.RequirePermission(permissions.POST_PUBLISH), postHandler.Schedule)
+			bloggerPost.DELETE(":id/schedule", middlewares.RequirePermission(permissions.POST_PUBLISH), postHandler.Unschedule)
+			bloggerPost.GET(":id/revisions", middlewares.RequirePermission(permissions.POST_CREATE), middlewares.Pagination(), revisionHandler.GetPage)
+			bloggerPost.GET(":id/revisions/diff", middlewares.RequirePermission(permissions.POST_CREATE), revisionHandler.Diff)
+			bloggerPost.POST(":id/revisions/:revisionId/restore", middlewares.RequirePermission(permissions.POST_CREATE), revisionHandler.Restore)
 		}
 
-		bloggerPostCategory := blogger.Group("post-category", middlewares.PostMatchesWithUser(db))
+		// commenting is comments:write, a token doesn't need posts:write for it
+		blogger.POST("posts/:id/comments", middlewares.RequireScope(scopes.COMMENTS_WRITE), middlewares.RequirePermission(permissions.COMMENT_CREATE), commentHandler.Create)
+
+		bloggerComment := blogger.Group("comments", middlewares.RequireScope(scopes.COMMENTS_WRITE), middlewares.RequirePermission(permissions.COMMENT_CREATE))
+		{
+			bloggerComment.PATCH(":id", commentHandler.Update)
+			bloggerComment.DELETE(":id", commentHandler.Delete)
+		}
+
+		bloggerModeration := blogger.Group("moderation", middlewares.RequireScope(scopes.COMMENTS_MODERATE), middlewares.RequirePermission(permissions.COMMENT_MODERATE))
+		{
+			bloggerModeration.GET("comments", middlewares.Pagination(), moderationHandler.Inbox)
+			bloggerModeration.PATCH("comments/:id", moderationHandler.Moderate)
+			bloggerModeration.GET("settings", moderationHandler.GetSettings)
+			bloggerModeration.PUT("settings", moderationHandler.UpdateSettings)
+		}
+
+		bloggerPostCategory := blogger.Group("post-category", middlewares.RequireScope(scopes.POSTS_WRITE), middlewares.RequirePermission(permissions.POST_CREATE), middlewares.PostMatchesWithUser(db))
 		{
 			bloggerPostCategory.POST("", postCategoryHandler.Create)
 			bloggerPostCategory.DELETE("", postCategoryHandler.Delete)
 		}
+
+		bloggerPostTag := blogger.Group("post-tag", middlewares.RequireScope(scopes.POSTS_WRITE), middlewares.RequirePermission(permissions.POST_CREATE), middlewares.PostMatchesWithUser(db))
+		{
+			bloggerPostTag.PUT("", postTagHandler.Set)
+			bloggerPostTag.POST("", postTagHandler.Create)
+			bloggerPostTag.DELETE("", postTagHandler.Delete)
+		}
 	}
 
-	admin := r.Group("/", middlewares.ValidateToken(), middlewares.Authorization(roles.ADMIN_PERMS) )
+	admin := r.Group("/", middlewares.ValidateToken(db, logger, revocations, keyRing))
 	{
-		adminCategory := admin.Group("categories")
+		adminCategory := admin.Group("categories", middlewares.RequireScope(scopes.CATEGORIES_ADMIN), middlewares.RequirePermission(permissions.TAXONOMY_MANAGE))
 		{
 			adminCategory.POST("", categoryHandler.Create)
 			adminCategory.DELETE(":id", categoryHandler.Delete)
 			adminCategory.PATCH("", categoryHandler.Update)
 		}
 
-		adminTag := admin.Group("tags")
+		adminTag := admin.Group("tags", middlewares.RequireScope(scopes.TAGS_ADMIN), middlewares.RequirePermission(permissions.TAXONOMY_MANAGE))
 		{
 			adminTag.POST("", tagHandler.Create)
 			adminTag.DELETE(":id", tagHandler.Delete)
 			adminTag.PATCH("", tagHandler.Update)
 		}
+
+		adminUser := admin.Group("users", middlewares.RequireScope(scopes.USERS_ADMIN), middlewares.RequirePermission(permissions.USER_MANAGE))
+		{
+			adminUser.GET("", middlewares.Pagination(), userHandler.GetPage)
+			adminUser.GET(":id", userHandler.Get)
+			adminUser.PUT(":id/role", userHandler.ChangeRole)
+			adminUser.POST(":id/disable", userHandler.Disable)
+			adminUser.POST(":id/enable", userHandler.Enable)
+			adminUser.POST(":id/password-reset", userHandler.ForcePasswordReset)
+			adminUser.DELETE(":id", userHandler.Delete)
+			adminUser.POST(":id/revoke-sessions", authHandler.RevokeUserSessions)
+		}
+
+		admin.GET("audit-log", middlewares.RequireScope(scopes.USERS_ADMIN), middlewares.RequirePermission(permissions.USER_MANAGE), middlewares.Pagination(), userHandler.AuditLog)
+
+		adminRole := admin.Group("roles", middlewares.SessionOnly(), middlewares.RequirePermission(permissions.ROLE_MANAGE))
+		{
+			adminRole.GET("", roleHandler.GetAll)
+			adminRole.POST("", roleHandler.Create)
+			adminRole.PUT(":id", roleHandler.Update)
+			adminRole.DELETE(":id", roleHandler.Delete)
+		}
+
+		admin.GET("permissions", middlewares.SessionOnly(), middlewares.RequirePermission(permissions.ROLE_MANAGE), roleHandler.GetPermissions)
 	}
 	
-	if os.Getenv("APP_ENV") == "PROD" {
-		log.Fatal(r.Run(":" + os.Getenv("PROD_PORT")))
-	} else {
-		log.Fatal(r.Run(":" + os.Getenv("DEV_PORT")))
+	serve(r)
+}
+
+// serve runs the server until SIGINT or SIGTERM. Readiness fails from then
+// on, and in-flight requests get SHUTDOWN_TIMEOUT_SECONDS to finish before
+// the background workers are stopped.
+func serve(handler http.Handler) {
+	server := &http.Server{
+		Addr: ":" + strconv.Itoa(cfg.Server.Port()),
+		Handler: handler,
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.ListenAndServe()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		log.Fatal(err)
+	case sig := <-stop:
+		logger.Info("shutting down", slog.String("signal", sig.String()))
+	}
+
+	healthHandler.ShuttingDown()
+	time.Sleep(time.Duration(cfg.Server.ShutdownDrainSeconds) * time.Second)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Server.ShutdownTimeoutSeconds)*time.Second)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		logger.Error("shutdown did not finish in time", slog.Any("error", err))
 	}
+
+	if err := <-serverErr; !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("server failed", slog.Any("error", err))
+	}
+
+	stopWorkers()
 }
 
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	dbPackage "github.com/noctispine/blog/cmd/db"
+)
+
+const migrateUsage = "usage: blog migrate up | down [steps] | status"
+
+func runMigrate(args []string) {
+	if len(args) == 0 {
+		log.Fatal(migrateUsage)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := dbPackage.MigrateUp(db)
+		for _, m := range applied {
+			fmt.Printf("applied %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				log.Fatal(migrateUsage)
+			}
+		}
+
+		rolledBack, err := dbPackage.MigrateDown(db, steps)
+		for _, m := range rolledBack {
+			fmt.Printf("rolled back %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case "status":
+		statuses, err := dbPackage.Status(db)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		for _, s := range statuses {
+			if s.Applied {
+				fmt.Printf("[x] %d_%s (applied %s)\n", s.Version, s.Name, s.AppliedAt.Format("2006-01-02 15:04:05"))
+			} else {
+				fmt.Printf("[ ] %d_%s\n", s.Version, s.Name)
+			}
+		}
+
+	default:
+		log.Fatal(migrateUsage)
+	}
+}
@@ -0,0 +1,15 @@
+package models
+
+import (
+	"time"
+)
+
+type AdminAuditLog struct {
+	ID int64 `json:"id"`
+	ActorID *int64 `json:"actorId" gorm:"column:actor_id"`
+	TargetUserID *int64 `json:"targetUserId" gorm:"column:target_user_id"`
+	TargetEmail string `json:"targetEmail" gorm:"column:target_email"`
+	Action string `json:"action"`
+	Details string `json:"details"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+}
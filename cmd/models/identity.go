@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// ExternalIdentity links an account of an OpenID Connect provider to a user.
+type ExternalIdentity struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"userId" gorm:"column:user_id"`
+	Issuer string `json:"issuer"`
+	Subject string `json:"subject"`
+	Email string `json:"email"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+	LastLoginAt *time.Time `json:"lastLoginAt" gorm:"column:last_login_at"`
+}
+
+// OIDCLoginState remembers a started login until the provider redirects back.
+type OIDCLoginState struct {
+	ID int64 `json:"id"`
+	StateHash string `json:"-" gorm:"column:state_hash"`
+	Nonce string `json:"-"`
+	CodeVerifier string `json:"-" gorm:"column:code_verifier"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"column:expires_at"`
+	UsedAt *time.Time `json:"usedAt" gorm:"column:used_at"`
+}
+
+func (OIDCLoginState) TableName() string {
+	return "oidc_login_state"
+}
@@ -4,6 +4,14 @@ import (
 	"time"
 )
 
+// Post publishing follows these rules:
+//   - published: IsPublished is true and PublishedAt is when it went live
+//   - scheduled: IsPublished is false and PublishedAt is in the future, the
+//     publisher worker flips IsPublished once that time has passed
+//   - draft: IsPublished is false and PublishedAt is nil
+//
+// Unpublishing a post clears PublishedAt and turns it back into a draft, so
+// publishing it again stamps a new PublishedAt.
 type Post struct {
 	ID     int64   `json:"id" validate:"omitempty"`
 	ParentID *int64 `json:"parentId" gorm:"column:parent_id" validate:"omitempty"`
@@ -13,23 +21,85 @@ type Post struct {
 	Summary string `json:"summary"`
 	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at" validate:"omitempty"`
 	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at" validate:"omitempty"`
-	PublishedAt time.Time `json:"publishedAt" gorm:"column:published_at" validate:"omitempty"`
+	PublishedAt *time.Time `json:"publishedAt" gorm:"column:published_at" validate:"omitempty"`
 	Content string `json:"content"`
 	IsPublished bool `json:"isPublished" gorm:"column:is_published"`
+	Tags []Tag `json:"tags" gorm:"-" validate:"-"`
+	Categories []Category `json:"categories" gorm:"-" validate:"-"`
+}
+
+// PostSearchResult is a post matched by full-text search, with its rank and a
+// snippet of the matching content. The snippet is escaped HTML, the matches
+// are wrapped in <mark>.
+type PostSearchResult struct {
+	Post `gorm:"embedded"`
+	Rank float64 `json:"rank" gorm:"column:rank"`
+	Snippet string `json:"snippet" gorm:"column:snippet"`
+}
+
+type SchedulePost struct {
+	PublishedAt time.Time `json:"publishedAt" validate:"required"`
+}
+
+// PostRevision is an immutable snapshot of a post, saved on every change.
+type PostRevision struct {
+	ID int64 `json:"id"`
+	PostID int64 `json:"postId" gorm:"column:post_id"`
+	UserID int64 `json:"userId" gorm:"column:user_id"`
+	Title string `json:"title"`
+	Summary string `json:"summary"`
+	Content string `json:"content"`
+	RestoredFrom *int64 `json:"restoredFrom,omitempty" gorm:"column:restored_from"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
 }
 
 type Comment struct {
-	ID     uint   `json:"id" pg:"id"`
-	ParentID uint `json:"parentId" pg:"parent_id"`
-	PostID uint `json:"postId" pg:"post_id"`
-	Title string `json:"title" pg:"title"`
-	Content string `json:"content" pg:"content"`
-	PublishedAt time.Time `json:"publishedAt" pg:"published_at"`
+	ID     int64   `json:"id" validate:"omitempty"`
+	ParentID *int64 `json:"parentId" gorm:"column:parent_id" validate:"omitempty"`
+	PostID int64 `json:"postId" gorm:"column:post_id" validate:"omitempty"`
+	UserID int64 `json:"userId" gorm:"column:user_id" validate:"omitempty"`
+	Title string `json:"title" validate:"max=100"`
+	Content string `json:"content" validate:"required,max=5000"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at" validate:"omitempty"`
+	UpdatedAt time.Time `json:"updatedAt" gorm:"column:updated_at" validate:"omitempty"`
+	PublishedAt time.Time `json:"publishedAt" gorm:"column:published_at" validate:"omitempty"`
+	Status string `json:"status" gorm:"column:status" validate:"omitempty"`
+	ModeratedBy *int64 `json:"moderatedBy,omitempty" gorm:"column:moderated_by" validate:"omitempty"`
+	ModeratedAt *time.Time `json:"moderatedAt,omitempty" gorm:"column:moderated_at" validate:"omitempty"`
+	Replies []*Comment `json:"replies,omitempty" gorm:"-" validate:"-"`
+}
+
+// ThreadedComment is a comment annotated with its depth in the reply tree,
+// used by the flattened comment listing.
+type ThreadedComment struct {
+	Comment `gorm:"embedded"`
+	Depth int `json:"depth" gorm:"column:depth"`
+}
+
+type UpdateComment struct {
+	Title string `json:"title" validate:"max=100"`
+	Content string `json:"content" validate:"required,max=5000"`
+}
+
+type ModerateComment struct {
+	Status string `json:"status" validate:"required,oneof=approved rejected spam"`
+}
+
+// ModerationSetting with a nil PostID holds the global settings,
+// otherwise it overrides them for a single post.
+type ModerationSetting struct {
+	ID int64 `json:"id" validate:"omitempty"`
+	PostID *int64 `json:"postId" gorm:"column:post_id" validate:"omitempty"`
+	AutoApproveKnown bool `json:"autoApproveKnown" gorm:"column:auto_approve_known"`
 }
 
 type PostTag struct {
-	PostID Post `json:"postId" gorm:"notNull"`
-	TagID Tag `json:"tagId" gorm:"notNull"`
+	PostID int64 `json:"postId" gorm:"column:post_id;notNull"`
+	TagID int64 `json:"tagId" gorm:"column:tag_id;notNull"`
+}
+
+type SetPostTags struct {
+	TagIDs []int64 `json:"tagIds" validate:"required,dive,min=1"`
 }
 
 type PostMeta struct {
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+type Role struct {
+	ID int `json:"id"`
+	Name string `json:"name"`
+	Description string `json:"description"`
+	BuiltIn bool `json:"builtIn" gorm:"column:built_in"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+	Permissions []string `json:"permissions" gorm:"-"`
+}
+
+type RolePermission struct {
+	RoleID int `gorm:"column:role_id;primaryKey"`
+	Permission string `gorm:"column:permission;primaryKey"`
+}
+
+// validations
+
+type CreateRole struct {
+	Name string `json:"name" validate:"required,min=1,max=50"`
+	Description string `json:"description" validate:"max=255"`
+	Permissions []string `json:"permissions" validate:"required,dive,min=1"`
+}
+
+type UpdateRole struct {
+	Description string `json:"description" validate:"max=255"`
+	Permissions []string `json:"permissions" validate:"required,dive,min=1"`
+}
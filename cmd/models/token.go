@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+)
+
+type RefreshToken struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"userId" gorm:"column:user_id"`
+	FamilyID string `json:"-" gorm:"column:family_id"`
+	TokenHash string `json:"-" gorm:"column:token_hash"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"column:expires_at"`
+	UsedAt *time.Time `json:"usedAt" gorm:"column:used_at"`
+	RevokedAt *time.Time `json:"revokedAt" gorm:"column:revoked_at"`
+}
+
+type PasswordResetToken struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"userId" gorm:"column:user_id"`
+	TokenHash string `json:"-" gorm:"column:token_hash"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"column:expires_at"`
+	UsedAt *time.Time `json:"usedAt" gorm:"column:used_at"`
+}
+
+type RecoveryCode struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"userId" gorm:"column:user_id"`
+	CodeHash string `json:"-" gorm:"column:code_hash"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+	UsedAt *time.Time `json:"usedAt" gorm:"column:used_at"`
+}
+
+type TwoFactorChallenge struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"userId" gorm:"column:user_id"`
+	TokenHash string `json:"-" gorm:"column:token_hash"`
+	Attempts int `json:"attempts" gorm:"column:attempts"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"column:expires_at"`
+	UsedAt *time.Time `json:"usedAt" gorm:"column:used_at"`
+}
+
+// PersonalAccessToken is a long lived credential for scripts. Scopes is a
+// space separated list of the scopes in constants/scopes.
+type PersonalAccessToken struct {
+	ID int64 `json:"id"`
+	UserID int64 `json:"userId" gorm:"column:user_id"`
+	Name string `json:"name"`
+	TokenHash string `json:"-" gorm:"column:token_hash"`
+	Scopes string `json:"scopes"`
+	CreatedAt time.Time `json:"createdAt" gorm:"column:created_at"`
+	ExpiresAt time.Time `json:"expiresAt" gorm:"column:expires_at"`
+	LastUsedAt *time.Time `json:"lastUsedAt" gorm:"column:last_used_at"`
+	RevokedAt *time.Time `json:"revokedAt" gorm:"column:revoked_at"`
+}
+
+// validations
+
+type RefreshTokenRequest struct {
+	RefreshToken string `json:"refreshToken" validate:"required"`
+}
+
+type ForgotPassword struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPassword struct {
+	Token string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6,max=256"`
+}
+
+type TwoFactorCode struct {
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type TwoFactorSignIn struct {
+	ChallengeToken string `json:"challengeToken" validate:"required"`
+	Code string `json:"code" validate:"required,max=32"`
+}
+
+type CreatePersonalAccessToken struct {
+	Name string `json:"name" validate:"required,min=1,max=100"`
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,oneof=posts:write comments:write comments:moderate categories:admin tags:admin users:admin"`
+	ExpiresInDays int `json:"expiresInDays" validate:"omitempty,min=1,max=365"`
+}
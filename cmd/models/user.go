@@ -9,12 +9,37 @@ type UserAccount struct {
 	FirstName string
 	LastName string
 	Email string
-	PasswordHash string `json:"omitempty"`
+	PasswordHash string `json:"-"`
 	RegisteredAt time.Time `json:"-"`
 	LastLoginAt time.Time `json:"-"`
 	IntroDesc string
 	Role int
 	ProfileDesc string	
+	TokensValidAfter *time.Time `json:"-"`
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty"`
+	VerificationSentAt *time.Time `json:"-"`
+	TOTPSecret *string `json:"-" gorm:"column:totp_secret"`
+	TOTPEnabledAt *time.Time `json:"-" gorm:"column:totp_enabled_at"`
+	TOTPLastCounter int64 `json:"-" gorm:"column:totp_last_counter"`
+	DisabledAt *time.Time `json:"disabledAt,omitempty"`
+	DisabledReason string `json:"-"`
+	PasswordResetRequired bool `json:"-"`
+}
+
+// UserSummary is what admins see of an account.
+type UserSummary struct {
+	ID int64 `json:"id"`
+	Email string `json:"email"`
+	FirstName string `json:"firstName"`
+	LastName string `json:"lastName"`
+	Role int `json:"role"`
+	RegisteredAt time.Time `json:"registeredAt"`
+	LastLoginAt time.Time `json:"lastLoginAt"`
+	VerifiedAt *time.Time `json:"verifiedAt"`
+	TwoFactorEnabled bool `json:"twoFactorEnabled"`
+	DisabledAt *time.Time `json:"disabledAt"`
+	DisabledReason string `json:"disabledReason"`
+	PasswordResetRequired bool `json:"passwordResetRequired"`
 }
 
 // validations
@@ -32,3 +57,11 @@ type LoginUser struct {
 	Email    string `json:"email" pg:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required,min=6,max=256"`	
 }
+
+type ChangeUserRole struct {
+	RoleID *int `json:"roleId" validate:"required,min=0"`
+}
+
+type DisableUser struct {
+	Reason string `json:"reason" validate:"max=255"`
+}
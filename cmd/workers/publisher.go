@@ -0,0 +1,146 @@
+package workers
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/health"
+	"gorm.io/gorm"
+)
+
+// how often the publisher looks for schedules when nothing wakes it up,
+// this also picks up posts scheduled through another instance
+const publisherPollInterval = time.Minute
+
+// the least the publisher waits between runs, so a schedule that is overdue
+// by the app's clock but not yet by the database's doesn't spin the loop
+const publisherMinWait = time.Second
+
+// Publisher publishes scheduled posts once their published_at has passed.
+// Schedules live in the post table, so nothing is lost on restart, and posts
+// are published with a single conditional UPDATE, so when several instances
+// run only one of them flips a given post.
+type Publisher struct {
+	db        *gorm.DB
+	logger    *slog.Logger
+	wake      chan struct{}
+	heartbeat health.Heartbeat
+}
+
+func NewPublisher(db *gorm.DB, logger *slog.Logger) *Publisher {
+	return &Publisher{
+		db:     db,
+		logger: logger,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Wake makes the publisher re-read the schedules, call it after a schedule
+// is created or changed.
+func (p *Publisher) Wake() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run publishes due posts until ctx is cancelled.
+func (p *Publisher) Run(ctx context.Context) {
+	failures := 0
+
+	for {
+		_, err := p.PublishDue()
+		p.heartbeat.Beat(err)
+
+		var wait time.Duration
+		if err != nil {
+			failures++
+			wait = backoff(failures)
+			p.logger.Error("publishing scheduled posts failed", slog.Any("error", err), slog.Duration("retry_in", wait))
+		} else {
+			failures = 0
+			wait = p.nextWait()
+		}
+
+		timer := time.NewTimer(wait)
+
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-p.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// Healthy fails when publishing failed or the loop stopped running.
+func (p *Publisher) Healthy() error {
+	return p.heartbeat.Healthy(2 * publisherPollInterval)
+}
+
+// PublishDue publishes every scheduled post whose time has come and returns
+// their ids.
+func (p *Publisher) PublishDue() ([]int64, error) {
+	var published []models.Post
+
+	err := p.db.Raw(`UPDATE post SET is_published = true, updated_at = now()
+		WHERE is_published = false AND published_at IS NOT NULL AND published_at <= now()
+		RETURNING id`).Scan(&published).Error
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, len(published))
+	for i, post := range published {
+		ids[i] = post.ID
+		p.logger.Info("published scheduled post", slog.Int64("post_id", post.ID))
+	}
+
+	return ids, nil
+}
+
+// nextWait is the time until the earliest pending schedule. It is measured
+// by the database's clock, the one PublishDue decides with.
+func (p *Publisher) nextWait() time.Duration {
+	var seconds *float64
+
+	err := p.db.Raw(`SELECT EXTRACT(EPOCH FROM min(published_at) - now()) FROM post
+		WHERE is_published = false AND published_at IS NOT NULL`).Scan(&seconds).Error
+	if err != nil {
+		p.logger.Error("reading the next schedule failed", slog.Any("error", err))
+		return publisherPollInterval
+	}
+
+	if seconds == nil {
+		return publisherPollInterval
+	}
+
+	return clampWait(time.Duration(*seconds * float64(time.Second)))
+}
+
+// clampWait keeps a wait between publisherMinWait and the poll interval.
+func clampWait(wait time.Duration) time.Duration {
+	if wait < publisherMinWait {
+		return publisherMinWait
+	}
+	if wait > publisherPollInterval {
+		return publisherPollInterval
+	}
+
+	return wait
+}
+
+// backoff doubles the wait with every failure in a row, up to the poll
+// interval.
+func backoff(failures int) time.Duration {
+	wait := publisherMinWait
+	for i := 1; i < failures && wait < publisherPollInterval; i++ {
+		wait *= 2
+	}
+
+	return clampWait(wait)
+}
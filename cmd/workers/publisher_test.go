@@ -0,0 +1,183 @@
+package workers
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/noctispine/blog/cmd/constants/roles"
+	dbPackage "github.com/noctispine/blog/cmd/db"
+	"github.com/noctispine/blog/cmd/models"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/schema"
+)
+
+// testDB connects to the database in TEST_DATABASE_DSN and migrates it. The
+// test is skipped without one.
+func testDB(t *testing.T) *gorm.DB {
+	t.Helper()
+
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+
+	db, err := gorm.Open(postgres.New(postgres.Config{
+		DSN:                  dsn,
+		PreferSimpleProtocol: true,
+	}), &gorm.Config{
+		NamingStrategy: schema.NamingStrategy{SingularTable: true},
+		Logger:         logger.Default.LogMode(logger.Silent),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dbPackage.MigrateUp(db); err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func testPublisher(db *gorm.DB) *Publisher {
+	return NewPublisher(db, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestClampWait(t *testing.T) {
+	tests := []struct {
+		wait time.Duration
+		want time.Duration
+	}{
+		{-time.Hour, publisherMinWait},
+		{0, publisherMinWait},
+		{10 * time.Second, 10 * time.Second},
+		{time.Hour, publisherPollInterval},
+	}
+
+	for _, tt := range tests {
+		if got := clampWait(tt.wait); got != tt.want {
+			t.Errorf("clampWait(%v) = %v, want %v", tt.wait, got, tt.want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{6, 32 * time.Second},
+		{7, publisherPollInterval},
+		{1000, publisherPollInterval},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.failures); got != tt.want {
+			t.Errorf("backoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestPublishDueOnce(t *testing.T) {
+	db := testDB(t)
+	suffix := time.Now().UnixNano()
+
+	author := models.UserAccount{
+		FirstName:    "Ada",
+		LastName:     "Lovelace",
+		Email:        fmt.Sprintf("publisher-%d@example.com", suffix),
+		PasswordHash: "unused",
+		Role:         roles.BLOGGER,
+		RegisteredAt: time.Now(),
+	}
+	if err := db.Create(&author).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := func(name string, at time.Time) int64 {
+		post := models.Post{
+			UserID:      author.ID,
+			Title:       name,
+			Slug:        fmt.Sprintf("%s-%d", name, suffix),
+			PublishedAt: &at,
+		}
+		if err := db.Create(&post).Error; err != nil {
+			t.Fatal(err)
+		}
+		return post.ID
+	}
+
+	due := map[int64]bool{}
+	for i := 0; i < 5; i++ {
+		due[schedule(fmt.Sprintf("due-%d", i), time.Now().Add(-time.Minute))] = true
+	}
+	later := schedule("later", time.Now().Add(time.Hour))
+
+	// instances race for the same schedules
+	published := map[int64]int{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ids, err := testPublisher(db).PublishDue()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			mu.Lock()
+			for _, id := range ids {
+				published[id]++
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	for id := range due {
+		if published[id] != 1 {
+			t.Errorf("post %d was published %d times, want once", id, published[id])
+		}
+	}
+	if published[later] != 0 {
+		t.Error("a post scheduled for later was published")
+	}
+
+	// a second run finds nothing left to do
+	ids, err := testPublisher(db).PublishDue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range ids {
+		if due[id] || id == later {
+			t.Errorf("post %d was published again", id)
+		}
+	}
+
+	var count int64
+	db.Model(&models.Post{}).Where("id IN ? AND is_published = ?", keysOf(due), true).Count(&count)
+	if count != int64(len(due)) {
+		t.Errorf("%d of %d due posts are published", count, len(due))
+	}
+}
+
+func keysOf(set map[int64]bool) []int64 {
+	keys := make([]int64, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -5,4 +5,6 @@ const (
 	PageSizeKey = "pageSize"
 	UserID = "userId"
 	UserRole = "userRole"
+	TokenScopes = "tokenScopes"
+	UserPermissions = "userPermissions"
 )
@@ -0,0 +1,201 @@
+package diff
+
+import (
+	"errors"
+	"strings"
+)
+
+type Op string
+
+const (
+	Equal  Op = "equal"
+	Insert Op = "insert"
+	Delete Op = "delete"
+)
+
+// MaxLines caps the lines of each side. The search takes O((N+M)D) time,
+// so bigger inputs are refused instead of keeping a request busy.
+const MaxLines = 5000
+
+var ErrTooLarge = errors.New("diff: too many lines to compare")
+
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+// Lines returns a line-level diff turning a into b, computed with the linear
+// space variant of Myers' O(ND) algorithm so that long posts with few
+// changes stay cheap and memory stays proportional to the input.
+func Lines(a, b string) ([]Line, error) {
+	linesA, linesB := splitLines(a), splitLines(b)
+	if len(linesA) > MaxLines || len(linesB) > MaxLines {
+		return nil, ErrTooLarge
+	}
+
+	return diff(linesA, linesB), nil
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+}
+
+// differ compares lines by id, equal lines share one, and collects the
+// edit script in order.
+type differ struct {
+	a, b   []string
+	ia, ib []int
+	lines  []Line
+	// frontiers reused by every middle snake search
+	forward, backward []int
+}
+
+func diff(a, b []string) []Line {
+	ids := map[string]int{}
+	intern := func(lines []string) []int {
+		interned := make([]int, len(lines))
+		for i, line := range lines {
+			id, ok := ids[line]
+			if !ok {
+				id = len(ids)
+				ids[line] = id
+			}
+			interned[i] = id
+		}
+		return interned
+	}
+
+	size := 2*(len(a)+len(b)) + 4
+	d := &differ{
+		a:        a,
+		b:        b,
+		ia:       intern(a),
+		ib:       intern(b),
+		lines:    make([]Line, 0, len(a)+len(b)),
+		forward:  make([]int, size),
+		backward: make([]int, size),
+	}
+
+	d.compare(0, len(a), 0, len(b))
+
+	return d.lines
+}
+
+// compare appends the edit script turning a[aLo:aHi] into b[bLo:bHi].
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	// common prefix and suffix don't need the search
+	prefix := 0
+	for aLo+prefix < aHi && bLo+prefix < bHi && d.ia[aLo+prefix] == d.ib[bLo+prefix] {
+		prefix++
+	}
+
+	suffix := 0
+	for aLo+prefix < aHi-suffix && bLo+prefix < bHi-suffix && d.ia[aHi-1-suffix] == d.ib[bHi-1-suffix] {
+		suffix++
+	}
+
+	d.equal(aLo, aLo+prefix)
+	aLo, bLo = aLo+prefix, bLo+prefix
+	aHi, bHi = aHi-suffix, bHi-suffix
+
+	switch {
+	case aLo == aHi:
+		for y := bLo; y < bHi; y++ {
+			d.lines = append(d.lines, Line{Insert, d.b[y]})
+		}
+	case bLo == bHi:
+		for x := aLo; x < aHi; x++ {
+			d.lines = append(d.lines, Line{Delete, d.a[x]})
+		}
+	default:
+		// both sides differ, so there are at least two edits and the
+		// middle snake splits them into two smaller problems
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		d.equal(x, u)
+		d.compare(u, aHi, v, bHi)
+	}
+
+	d.equal(aHi, aHi+suffix)
+}
+
+func (d *differ) equal(from, to int) {
+	for x := from; x < to; x++ {
+		d.lines = append(d.lines, Line{Equal, d.a[x]})
+	}
+}
+
+// middleSnake runs the search from both ends at once until the paths meet
+// and returns the snake, (x, y) to (u, v), in the middle of an optimal path.
+// Only the two frontiers are kept, which is what makes the space linear.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	offset := n + m + 1
+
+	// forward holds the furthest x per diagonal k = x - y from the start,
+	// backward the furthest distance per diagonal from the end
+	forward, backward := d.forward, d.backward
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	for depth := 0; depth <= (n+m+1)/2; depth++ {
+		for k := -depth; k <= depth; k += 2 {
+			var x int
+			if k == -depth || (k != depth && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			if x > n || y > m || y < 0 {
+				// off the edit graph, nothing further on this diagonal
+				forward[offset+k] = x
+				continue
+			}
+
+			startX, startY := x, y
+			for x < n && y < m && d.ia[aLo+x] == d.ib[bLo+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+
+			// the backward paths of the previous depth are on the other parity
+			if reverse := delta - k; odd && reverse >= -(depth-1) && reverse <= depth-1 && x+backward[offset+reverse] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y
+			}
+		}
+
+		for k := -depth; k <= depth; k += 2 {
+			var x int
+			if k == -depth || (k != depth && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			if x > n || y > m || y < 0 {
+				backward[offset+k] = x
+				continue
+			}
+
+			startX, startY := x, y
+			for x < n && y < m && d.ia[aHi-1-x] == d.ib[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+
+			if forwardK := delta - k; !odd && forwardK >= -depth && forwardK <= depth && x+forward[offset+forwardK] >= n {
+				return aHi - x, bHi - y, aHi - startX, bHi - startY
+			}
+		}
+	}
+
+	// unreachable, the paths always meet by depth (n+m+1)/2
+	panic("diff: middle snake not found")
+}
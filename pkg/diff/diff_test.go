@@ -0,0 +1,160 @@
+package diff
+
+import (
+	"errors"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Line
+	}{
+		{"both empty", "", "", []Line{}},
+		{"identical", "a\nb", "a\nb", []Line{{Equal, "a"}, {Equal, "b"}}},
+		{"insert into empty", "", "a\nb", []Line{{Insert, "a"}, {Insert, "b"}}},
+		{"delete everything", "a\nb", "", []Line{{Delete, "a"}, {Delete, "b"}}},
+		{
+			"change in the middle",
+			"a\nb\nc",
+			"a\nx\nc",
+			[]Line{{Equal, "a"}, {Delete, "b"}, {Insert, "x"}, {Equal, "c"}},
+		},
+		{
+			"insert at the start",
+			"b\nc",
+			"a\nb\nc",
+			[]Line{{Insert, "a"}, {Equal, "b"}, {Equal, "c"}},
+		},
+		{
+			"delete at the end",
+			"a\nb\nc",
+			"a\nb",
+			[]Line{{Equal, "a"}, {Equal, "b"}, {Delete, "c"}},
+		},
+		{
+			"crlf line endings",
+			"a\r\nb",
+			"a\nc",
+			[]Line{{Equal, "a"}, {Delete, "b"}, {Insert, "c"}},
+		},
+		{
+			"moved line",
+			"a\nb\nc\nd",
+			"b\nc\na\nd",
+			[]Line{{Delete, "a"}, {Equal, "b"}, {Equal, "c"}, {Insert, "a"}, {Equal, "d"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Lines(tt.a, tt.b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lines(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinesTooLarge(t *testing.T) {
+	long := strings.Repeat("line\n", MaxLines)
+
+	tests := []struct {
+		name string
+		a, b string
+	}{
+		{"old side", long, "a"},
+		{"new side", "a", long},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Lines(tt.a, tt.b); !errors.Is(err, ErrTooLarge) {
+				t.Errorf("err = %v, want ErrTooLarge", err)
+			}
+		})
+	}
+}
+
+// TestLinesMinimal checks random inputs against a dynamic programming
+// longest common subsequence: the script has to rebuild both sides and
+// keep as many lines as possible.
+func TestLinesMinimal(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	for i := 0; i < 5000; i++ {
+		a := randomLines(random, random.Intn(15), 1+random.Intn(4))
+		b := randomLines(random, random.Intn(15), 1+random.Intn(4))
+
+		got, err := Lines(strings.Join(a, "\n"), strings.Join(b, "\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var fromA, fromB []string
+		equal := 0
+		for _, line := range got {
+			switch line.Op {
+			case Equal:
+				fromA = append(fromA, line.Text)
+				fromB = append(fromB, line.Text)
+				equal++
+			case Delete:
+				fromA = append(fromA, line.Text)
+			case Insert:
+				fromB = append(fromB, line.Text)
+			}
+		}
+
+		if !reflect.DeepEqual(fromA, nilIfEmpty(a)) || !reflect.DeepEqual(fromB, nilIfEmpty(b)) {
+			t.Fatalf("Lines(%q, %q) = %v doesn't rebuild both sides", a, b, got)
+		}
+		if want := longestCommon(a, b); equal != want {
+			t.Fatalf("Lines(%q, %q) keeps %d lines, want %d", a, b, equal, want)
+		}
+	}
+}
+
+func randomLines(random *rand.Rand, n int, alphabet int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = string(rune('a' + random.Intn(alphabet)))
+	}
+	return lines
+}
+
+func nilIfEmpty(lines []string) []string {
+	if len(lines) == 0 {
+		return nil
+	}
+	return lines
+}
+
+func longestCommon(a, b []string) int {
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			case lengths[i+1][j] > lengths[i][j+1]:
+				lengths[i][j] = lengths[i+1][j]
+			default:
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	return lengths[0][0]
+}
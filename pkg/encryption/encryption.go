@@ -0,0 +1,78 @@
+// Package encryption seals small secrets kept in the database, like private
+// signing keys and TOTP secrets, with AES-256-GCM.
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// sealed values are this prefix and the base64 of the GCM nonce followed by
+// the ciphertext
+const Prefix = "aes256gcm:"
+
+var (
+	ErrMissingSecret = errors.New("encryption secret is missing")
+	ErrMalformed     = errors.New("encrypted value is malformed")
+)
+
+type Key struct {
+	aead cipher.AEAD
+}
+
+// NewKey derives the AES-256 key from secret.
+func NewKey(secret string) (*Key, error) {
+	if secret == "" {
+		return nil, ErrMissingSecret
+	}
+
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{aead: aead}, nil
+}
+
+// IsEncrypted reports whether a stored value was sealed by Encrypt.
+func IsEncrypted(stored string) bool {
+	return strings.HasPrefix(stored, Prefix)
+}
+
+// Encrypt seals plaintext. context is authenticated with it, pass what the
+// value belongs to, e.g. the id of its row, so it can't be moved elsewhere.
+func (k *Key) Encrypt(plaintext []byte, context string) (string, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := k.aead.Seal(nonce, nonce, plaintext, []byte(context))
+	return Prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value sealed by Encrypt with the same context.
+func (k *Key) Decrypt(stored string, context string) ([]byte, error) {
+	if !IsEncrypted(stored) {
+		return nil, ErrMalformed
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(stored, Prefix))
+	if err != nil || len(sealed) < k.aead.NonceSize() {
+		return nil, ErrMalformed
+	}
+
+	nonce, ciphertext := sealed[:k.aead.NonceSize()], sealed[k.aead.NonceSize():]
+	return k.aead.Open(nil, nonce, ciphertext, []byte(context))
+}
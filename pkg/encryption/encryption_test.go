@@ -0,0 +1,70 @@
+package encryption
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNewKey(t *testing.T) {
+	if _, err := NewKey(""); !errors.Is(err, ErrMissingSecret) {
+		t.Errorf("err = %v, want ErrMissingSecret", err)
+	}
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := NewKey("0123456789abcdef0123456789abcdef")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewKey("another secret of at least 32 chars")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext := []byte("JBSWY3DPEHPK3PXP")
+	sealed, err := key.Encrypt(plaintext, "user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsEncrypted(sealed) || strings.Contains(sealed, string(plaintext)) {
+		t.Fatalf("sealed = %q", sealed)
+	}
+
+	again, err := key.Encrypt(plaintext, "user:1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again == sealed {
+		t.Error("sealing twice gave the same value, the nonce isn't random")
+	}
+
+	tests := []struct {
+		name    string
+		key     *Key
+		stored  string
+		context string
+		wantErr bool
+	}{
+		{"round trip", key, sealed, "user:1", false},
+		{"other context", key, sealed, "user:2", true},
+		{"wrong secret", other, sealed, "user:1", true},
+		{"truncated", key, Prefix + "AAAA", "user:1", true},
+		{"not base64", key, Prefix + "!!!", "user:1", true},
+		{"tampered", key, sealed[:len(sealed)-4] + "AAAA", "user:1", true},
+		{"not encrypted", key, string(plaintext), "user:1", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.key.Decrypt(tt.stored, tt.context)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != string(plaintext) {
+				t.Errorf("Decrypt = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
@@ -0,0 +1,50 @@
+// Package health lets background workers report whether they are still
+// doing their job, for the readiness probe.
+package health
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var ErrNotStarted = errors.New("has not run yet")
+
+// Heartbeat records the outcome of the last iteration of a worker's loop.
+// The zero value is ready to use.
+type Heartbeat struct {
+	mu   sync.Mutex
+	last time.Time
+	err  error
+}
+
+// Beat records an iteration, err is nil when it succeeded.
+func (h *Heartbeat) Beat(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.last = time.Now()
+	h.err = err
+}
+
+// Healthy fails when the last iteration failed or when there was none
+// within maxAge, i.e. the loop is stuck or was never started.
+func (h *Heartbeat) Healthy(maxAge time.Duration) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.last.IsZero() {
+		return ErrNotStarted
+	}
+
+	if h.err != nil {
+		return h.err
+	}
+
+	if since := time.Since(h.last); since > maxAge {
+		return fmt.Errorf("last ran %s ago", since.Round(time.Second))
+	}
+
+	return nil
+}
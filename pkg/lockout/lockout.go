@@ -0,0 +1,136 @@
+// Package lockout counts failed attempts per key and locks a key out for a
+// growing amount of time once it fails too often.
+package lockout
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter tracks failed attempts per key, e.g. an account or a client IP.
+type Limiter interface {
+	// Check returns how long the key is still locked out, zero if it may try.
+	Check(ctx context.Context, key string) (time.Duration, error)
+	// Fail records a failed attempt and returns the lockout it started, if any.
+	Fail(ctx context.Context, key string) (time.Duration, error)
+	// Reset forgets the key's failures.
+	Reset(ctx context.Context, key string) error
+}
+
+type Policy struct {
+	// failures allowed before the first lockout
+	Threshold int
+	// length of the first lockout, doubled with every further failure
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// failures are forgotten after this long without a new one
+	Window time.Duration
+}
+
+// Delay returns the lockout that follows the given number of failures.
+func (p Policy) Delay(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	delay := p.BaseDelay
+	for i := p.Threshold; i < failures; i++ {
+		delay *= 2
+		if delay >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+
+	if delay > p.MaxDelay {
+		return p.MaxDelay
+	}
+
+	return delay
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// MemoryLimiter keeps the counters in process. It only protects a single
+// instance, use PostgresLimiter when several instances serve sign ins.
+type MemoryLimiter struct {
+	policy Policy
+	// the clock, replaced in tests
+	now func() time.Time
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+func NewMemoryLimiter(policy Policy) *MemoryLimiter {
+	return &MemoryLimiter{
+		policy:  policy,
+		now:     time.Now,
+		entries: map[string]*entry{},
+	}
+}
+
+func (l *MemoryLimiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if e, ok := l.entries[key]; ok {
+		if remaining := e.lockedUntil.Sub(l.now()); remaining > 0 {
+			return remaining, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func (l *MemoryLimiter) Fail(ctx context.Context, key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.sweep(now)
+
+	e, ok := l.entries[key]
+	if !ok || now.Sub(e.lastFailure) > l.policy.Window {
+		e = &entry{}
+		l.entries[key] = e
+	}
+
+	e.failures++
+	e.lastFailure = now
+
+	delay := l.policy.Delay(e.failures)
+	if delay > 0 {
+		e.lockedUntil = now.Add(delay)
+	}
+
+	return delay, nil
+}
+
+func (l *MemoryLimiter) Reset(ctx context.Context, key string) error {
+	l.mu.Lock()
+	delete(l.entries, key)
+	l.mu.Unlock()
+
+	return nil
+}
+
+// sweep drops forgotten keys, at most once per window.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < l.policy.Window {
+		return
+	}
+
+	for key, e := range l.entries {
+		if now.Sub(e.lastFailure) > l.policy.Window && now.After(e.lockedUntil) {
+			delete(l.entries, key)
+		}
+	}
+
+	l.lastSweep = now
+}
@@ -0,0 +1,181 @@
+package lockout
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	policy := Policy{Threshold: 3, BaseDelay: 30 * time.Second, MaxDelay: 5 * time.Minute, Window: time.Hour}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 0},
+		{3, 30 * time.Second},
+		{4, time.Minute},
+		{5, 2 * time.Minute},
+		{6, 4 * time.Minute},
+		{7, 5 * time.Minute},
+		{100, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := policy.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestPolicyDelayBaseAboveMax(t *testing.T) {
+	policy := Policy{Threshold: 1, BaseDelay: time.Hour, MaxDelay: time.Minute}
+
+	if got := policy.Delay(1); got != time.Minute {
+		t.Errorf("Delay(1) = %v, want the maximum of %v", got, time.Minute)
+	}
+}
+
+// clock is a fake time source for the memory limiter.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func (c *clock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+func newTestLimiter(policy Policy) (*MemoryLimiter, *clock) {
+	c := &clock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	limiter := NewMemoryLimiter(policy)
+	limiter.now = c.Now
+	return limiter, c
+}
+
+func TestMemoryLimiter(t *testing.T) {
+	policy := Policy{Threshold: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: 10 * time.Minute}
+	ctx := context.Background()
+
+	type step struct {
+		// advance the clock before acting
+		advance time.Duration
+		// "fail", "check" or "reset"
+		action string
+		want   time.Duration
+	}
+
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "locks out at the threshold",
+			steps: []step{
+				{0, "fail", 0},
+				{0, "check", 0},
+				{0, "fail", time.Minute},
+				{0, "check", time.Minute},
+				{20 * time.Second, "check", 40 * time.Second},
+				{40 * time.Second, "check", 0},
+			},
+		},
+		{
+			name: "doubles with every further failure",
+			steps: []step{
+				{0, "fail", 0},
+				{0, "fail", time.Minute},
+				{time.Minute, "fail", 2 * time.Minute},
+				{2 * time.Minute, "fail", 4 * time.Minute},
+			},
+		},
+		{
+			name: "failures are forgotten after the window",
+			steps: []step{
+				{0, "fail", 0},
+				{10*time.Minute + time.Second, "fail", 0},
+				{0, "check", 0},
+			},
+		},
+		{
+			name: "failures within the window add up",
+			steps: []step{
+				{0, "fail", 0},
+				{9 * time.Minute, "fail", time.Minute},
+			},
+		},
+		{
+			name: "reset forgets the failures",
+			steps: []step{
+				{0, "fail", 0},
+				{0, "fail", time.Minute},
+				{0, "reset", 0},
+				{0, "check", 0},
+				{0, "fail", 0},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, c := newTestLimiter(policy)
+
+			for i, s := range tt.steps {
+				c.Advance(s.advance)
+
+				var got time.Duration
+				var err error
+				switch s.action {
+				case "fail":
+					got, err = limiter.Fail(ctx, "key")
+				case "check":
+					got, err = limiter.Check(ctx, "key")
+				case "reset":
+					err = limiter.Reset(ctx, "key")
+				}
+
+				if err != nil {
+					t.Fatalf("step %d: %v", i, err)
+				}
+				if got != s.want {
+					t.Errorf("step %d: %s = %v, want %v", i, s.action, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryLimiterKeysAreSeparate(t *testing.T) {
+	limiter, _ := newTestLimiter(Policy{Threshold: 1, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Hour})
+	ctx := context.Background()
+
+	if wait, _ := limiter.Fail(ctx, "account:a"); wait != time.Minute {
+		t.Fatalf("Fail = %v, want %v", wait, time.Minute)
+	}
+
+	if wait, _ := limiter.Check(ctx, "account:b"); wait != 0 {
+		t.Errorf("another key is locked out for %v", wait)
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	limiter, c := newTestLimiter(Policy{Threshold: 5, BaseDelay: time.Minute, MaxDelay: time.Hour, Window: time.Minute})
+	ctx := context.Background()
+
+	limiter.Fail(ctx, "old")
+	c.Advance(2 * time.Minute)
+	limiter.Fail(ctx, "new")
+
+	if _, ok := limiter.entries["old"]; ok {
+		t.Error("a key past its window wasn't swept")
+	}
+	if _, ok := limiter.entries["new"]; !ok {
+		t.Error("a current key was swept")
+	}
+}
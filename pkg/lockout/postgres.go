@@ -0,0 +1,113 @@
+package lockout
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type attempt struct {
+	Key           string     `gorm:"column:key;primaryKey"`
+	Failures      int        `gorm:"column:failures"`
+	LastFailureAt time.Time  `gorm:"column:last_failure_at"`
+	LockedUntil   *time.Time `gorm:"column:locked_until"`
+}
+
+func (attempt) TableName() string {
+	return "sign_in_attempt"
+}
+
+// PostgresLimiter keeps the counters in the sign_in_attempt table, so every
+// instance sees the same failures.
+type PostgresLimiter struct {
+	db     *gorm.DB
+	logger *slog.Logger
+	policy Policy
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresLimiter(db *gorm.DB, logger *slog.Logger, policy Policy) *PostgresLimiter {
+	return &PostgresLimiter{
+		db:     db,
+		logger: logger,
+		policy: policy,
+	}
+}
+
+func (l *PostgresLimiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	var row attempt
+
+	if err := l.db.WithContext(ctx).Where("key = ?", key).First(&row).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	if row.LockedUntil != nil {
+		if remaining := time.Until(*row.LockedUntil); remaining > 0 {
+			return remaining, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func (l *PostgresLimiter) Fail(ctx context.Context, key string) (time.Duration, error) {
+	now := time.Now()
+	l.sweep(ctx, now)
+
+	// the upsert counts concurrent failures from every instance exactly once
+	var failures int
+	if err := l.db.WithContext(ctx).Raw(`
+		INSERT INTO sign_in_attempt (key, failures, last_failure_at)
+		VALUES (@key, 1, @now)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN sign_in_attempt.last_failure_at < @forgetBefore THEN 1 ELSE sign_in_attempt.failures + 1 END,
+			last_failure_at = @now
+		RETURNING failures`,
+		sql.Named("key", key),
+		sql.Named("now", now),
+		sql.Named("forgetBefore", now.Add(-l.policy.Window)),
+	).Scan(&failures).Error; err != nil {
+		return 0, err
+	}
+
+	delay := l.policy.Delay(failures)
+	if delay > 0 {
+		if err := l.db.WithContext(ctx).Model(&attempt{}).Where("key = ?", key).Update("locked_until", now.Add(delay)).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	return delay, nil
+}
+
+func (l *PostgresLimiter) Reset(ctx context.Context, key string) error {
+	return l.db.WithContext(ctx).Where("key = ?", key).Delete(&attempt{}).Error
+}
+
+// sweep deletes forgotten keys, at most once per window and instance.
+func (l *PostgresLimiter) sweep(ctx context.Context, now time.Time) {
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) < l.policy.Window {
+		l.mu.Unlock()
+		return
+	}
+	l.lastSweep = now
+	l.mu.Unlock()
+
+	if err := l.db.WithContext(ctx).
+		Where("last_failure_at < ? AND (locked_until IS NULL OR locked_until < ?)", now.Add(-l.policy.Window), now).
+		Delete(&attempt{}).Error; err != nil {
+		l.logger.ErrorContext(ctx, "sweeping sign in attempts failed", slog.Any("error", err))
+	}
+}
@@ -1,49 +1,122 @@
 package middlewares
 
 import (
+	"errors"
+	"log/slog"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/golang-jwt/jwt"
 	"github.com/noctispine/blog/cmd/handlers"
 	"github.com/noctispine/blog/pkg/constants/keys"
+	"github.com/noctispine/blog/pkg/logging"
+	"github.com/noctispine/blog/pkg/revocation"
+	"github.com/noctispine/blog/pkg/signing"
 	"github.com/noctispine/blog/pkg/utils"
+	"gorm.io/gorm"
 )
-func ValidateToken() gin.HandlerFunc {
+// ValidateToken checks the bearer token in the Authorization header and
+// rejects tokens revoked through the store, which includes every token of a
+// disabled account. Personal access tokens are accepted as well, their
+// scopes are kept for RequireScope.
+func ValidateToken(db *gorm.DB, logger *slog.Logger, revocations *revocation.Store, keyRing *signing.KeyRing) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		tokenValue := c.GetHeader("Authorization")
-		claims := &handlers.Claims{}
+		token, ok := handlers.BearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.AbortWithStatus(http.StatusUnauthorized)
+			return
+		}
+
+		if strings.HasPrefix(token, handlers.PersonalAccessTokenPrefix) {
+			user, scopes, err := handlers.AuthenticatePersonalAccessToken(c.Request.Context(), db, logger, token)
+			if err != nil {
+				if errors.Is(err, handlers.ErrInvalidPersonalAccessToken) {
+					c.AbortWithStatus(http.StatusUnauthorized)
+					return
+				}
 
-		tkn, err := jwt.ParseWithClaims(tokenValue, claims,
-			func(token *jwt.Token) (interface{}, error){
-				return []byte(os.Getenv("JWT_SECRET")), nil
-		})
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
 
+			granted, err := handlers.RolePermissions(db, user.Role)
+			if err != nil {
+				c.AbortWithStatus(http.StatusInternalServerError)
+				return
+			}
+
+			logging.AddAttrs(c.Request.Context(), slog.Int64("user_id", user.ID))
+			c.Set(keys.UserID, user.ID)
+			c.Set(keys.UserRole, user.Role)
+			c.Set(keys.UserPermissions, granted)
+			c.Set(keys.TokenScopes, scopes)
+			c.Next()
+			return
+		}
 
+		claims, err := handlers.ParseAccessToken(keyRing, token)
 		if err != nil {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
-		if tkn == nil || !tkn.Valid {
+		var issuedAt time.Time
+		if claims.IssuedAt != nil {
+			issuedAt = claims.IssuedAt.Time
+		}
+
+		if revocations.IsRevoked(claims.ID, claims.UserID, issuedAt) {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
 
+		logging.AddAttrs(c.Request.Context(), slog.Int64("user_id", claims.UserID))
 		c.Set(keys.UserID, claims.UserID)
 		c.Set(keys.UserRole, claims.Role)
+		c.Set(keys.UserPermissions, claims.Permissions)
 		c.Next()
 	}
 }
 
-func Authorization(avaliableRoles []int) gin.HandlerFunc {
+// RequirePermission lets the request through if the user's role grants the
+// permission. It has to run after ValidateToken.
+func RequirePermission(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if utils.Contains(avaliableRoles, c.GetInt(keys.UserRole)){
+		if handlers.HasPermission(c, permission) {
 			c.Next()
 			return
 		}
 
-		c.AbortWithStatus(http.StatusUnauthorized)
+		c.AbortWithStatus(http.StatusForbidden)
 	}
-}
\ No newline at end of file
+}
+
+// RequireScope limits requests made with a personal access token to the
+// scopes it was granted. Signed in sessions aren't scoped, the role checks
+// still apply to both.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scopes, ok := c.Get(keys.TokenScopes)
+		if !ok || utils.Contains(scopes.([]string), scope) {
+			c.Next()
+			return
+		}
+
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+			"error": "token is missing the " + scope + " scope"})
+	}
+}
+
+// SessionOnly keeps personal access tokens away from account management, so
+// a leaked token can't be used to mint more tokens or change the second factor.
+func SessionOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(keys.TokenScopes); ok {
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		c.Next()
+	}
+}
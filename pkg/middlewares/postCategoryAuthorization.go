@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/constants/permissions"
+	"github.com/noctispine/blog/cmd/handlers"
 	"github.com/noctispine/blog/cmd/models"
 	"github.com/noctispine/blog/pkg/constants/keys"
 	"github.com/noctispine/blog/pkg/responses"
@@ -33,7 +35,7 @@ func PostMatchesWithUser(db *gorm.DB) gin.HandlerFunc {
 			return
 		}
 	
-		if post.UserID != c.GetInt64(keys.UserID) {
+		if post.UserID != c.GetInt64(keys.UserID) && !handlers.HasPermission(c, permissions.POST_EDIT_ANY) {
 			c.AbortWithStatus(http.StatusUnauthorized)
 			return
 		}
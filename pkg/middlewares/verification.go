@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/noctispine/blog/cmd/models"
+	"github.com/noctispine/blog/pkg/constants/keys"
+	"gorm.io/gorm"
+)
+
+// RequireVerifiedEmail lets only users who confirmed their email address
+// through. It has to run after ValidateToken.
+func RequireVerifiedEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var count int64
+
+		if err := db.Model(&models.UserAccount{}).Where("id = ? AND verified_at IS NOT NULL", c.GetInt64(keys.UserID)).Count(&count).Error; err != nil {
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		if count == 0 {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{
+				"error": "email address is not verified"})
+			return
+		}
+
+		c.Next()
+	}
+}
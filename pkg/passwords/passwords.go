@@ -0,0 +1,63 @@
+// Package passwords hashes passwords into self describing strings, so the
+// algorithm and its parameters can change without invalidating old hashes.
+package passwords
+
+import (
+	"errors"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+type Hasher interface {
+	// Hash returns the encoded hash of the password.
+	Hash(password string) (string, error)
+	// Verify reports whether the password matches the encoded hash.
+	Verify(password string, encoded string) (bool, error)
+	// Recognizes reports whether the encoded hash is of this hasher's algorithm.
+	Recognizes(encoded string) bool
+	// NeedsRehash reports whether the encoded hash was made with other
+	// parameters than the hasher's current ones.
+	NeedsRehash(encoded string) bool
+}
+
+// Chain hashes with its preferred hasher and verifies hashes of any of its
+// hashers. Hashes not made by the preferred hasher need a rehash.
+type Chain struct {
+	preferred Hasher
+	hashers   []Hasher
+}
+
+func NewChain(preferred Hasher, others ...Hasher) *Chain {
+	return &Chain{
+		preferred: preferred,
+		hashers:   append([]Hasher{preferred}, others...),
+	}
+}
+
+func (c *Chain) Hash(password string) (string, error) {
+	return c.preferred.Hash(password)
+}
+
+func (c *Chain) Verify(password string, encoded string) (bool, error) {
+	for _, hasher := range c.hashers {
+		if hasher.Recognizes(encoded) {
+			return hasher.Verify(password, encoded)
+		}
+	}
+
+	return false, ErrUnknownHash
+}
+
+func (c *Chain) Recognizes(encoded string) bool {
+	for _, hasher := range c.hashers {
+		if hasher.Recognizes(encoded) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *Chain) NeedsRehash(encoded string) bool {
+	return !c.preferred.Recognizes(encoded) || c.preferred.NeedsRehash(encoded)
+}
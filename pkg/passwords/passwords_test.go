@@ -0,0 +1,187 @@
+package passwords
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// the reference implementation's hash of "password" with the salt "somesalt"
+const referenceArgon2id = "$argon2id$v=19$m=65536,t=2,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"
+
+// small parameters keep the tests fast
+func testArgon2id() *Argon2id {
+	return NewArgon2id(64, 1, 1)
+}
+
+func TestArgon2idHashVerify(t *testing.T) {
+	hasher := testArgon2id()
+
+	encoded, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash = %s, want the PHC format with the hasher's parameters", encoded)
+	}
+
+	tests := []struct {
+		password string
+		want     bool
+	}{
+		{"correct horse", true},
+		{"Correct horse", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		got, err := hasher.Verify(tt.password, encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Verify(%q) = %v, want %v", tt.password, got, tt.want)
+		}
+	}
+
+	other, err := hasher.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == encoded {
+		t.Error("two hashes of the same password share a salt")
+	}
+}
+
+func TestArgon2idVerifyReference(t *testing.T) {
+	// the parameters come from the hash, not from the hasher
+	ok, err := testArgon2id().Verify("password", referenceArgon2id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("the reference hash didn't verify")
+	}
+}
+
+func TestArgon2idDecodeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{"bcrypt hash", "$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy"},
+		{"wrong variant", "$argon2i$v=19$m=64,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"},
+		{"missing key", "$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ"},
+		{"old version", "$argon2id$v=16$m=64,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"},
+		{"bad parameters", "$argon2id$v=19$m=x,t=1,p=1$c29tZXNhbHQ$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"},
+		{"bad salt", "$argon2id$v=19$m=64,t=1,p=1$!!!$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"},
+		{"empty salt", "$argon2id$v=19$m=64,t=1,p=1$$CTFhFdXPJO1aFaMaO6Mm5c8y7cJHAph8ArZWb2GRPPc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := testArgon2id().Verify("password", tt.encoded); err == nil {
+				t.Errorf("Verify accepted %s", tt.encoded)
+			}
+		})
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	current := testArgon2id()
+	encoded, err := current.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		hasher *Argon2id
+		want   bool
+	}{
+		{"same parameters", testArgon2id(), false},
+		{"more memory", NewArgon2id(128, 1, 1), true},
+		{"more iterations", NewArgon2id(64, 2, 1), true},
+		{"more parallelism", NewArgon2id(64, 1, 2), true},
+		{"longer salt", &Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 32, KeyLength: 32}, true},
+		{"longer key", &Argon2id{Memory: 64, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 64}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.hasher.NeedsRehash(encoded); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !current.NeedsRehash("garbage") {
+		t.Error("an undecodable hash doesn't need a rehash")
+	}
+}
+
+func TestBcryptNeedsRehash(t *testing.T) {
+	encoded, err := NewBcrypt(4).Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cost    int
+		encoded string
+		want    bool
+	}{
+		{"same cost", 4, encoded, false},
+		{"higher cost", 5, encoded, true},
+		{"not bcrypt", 4, "garbage", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewBcrypt(tt.cost).NeedsRehash(tt.encoded); got != tt.want {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChain(t *testing.T) {
+	bcryptHasher := NewBcrypt(4)
+	chain := NewChain(testArgon2id(), bcryptHasher)
+
+	preferred, err := chain.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacy, err := bcryptHasher.Hash("password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		encoded    string
+		wantOK     bool
+		wantErr    error
+		wantRehash bool
+	}{
+		{"preferred hash", preferred, true, nil, false},
+		{"other hasher's hash", legacy, true, nil, true},
+		{"unknown format", "plaintext", false, ErrUnknownHash, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := chain.Verify("password", tt.encoded)
+			if !errors.Is(err, tt.wantErr) || ok != tt.wantOK {
+				t.Errorf("Verify = %v, %v, want %v, %v", ok, err, tt.wantOK, tt.wantErr)
+			}
+			if got := chain.NeedsRehash(tt.encoded); got != tt.wantRehash {
+				t.Errorf("NeedsRehash = %v, want %v", got, tt.wantRehash)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package revocation
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/noctispine/blog/pkg/health"
+	"gorm.io/gorm"
+)
+
+// how often revocations made by other instances are pulled from the database
+const syncInterval = 15 * time.Second
+
+// Store answers whether an access token was revoked without a database round
+// trip per request. Revocations are written to the database and to the local
+// cache at once, revocations made by other instances are picked up by Run
+// within syncInterval.
+type Store struct {
+	db     *gorm.DB
+	logger *slog.Logger
+
+	mu         sync.RWMutex
+	revoked    map[string]time.Time
+	validAfter map[int64]time.Time
+	lastSync   time.Time
+
+	heartbeat health.Heartbeat
+}
+
+type revokedToken struct {
+	JTI       string    `gorm:"column:jti;primaryKey"`
+	UserID    int64     `gorm:"column:user_id"`
+	ExpiresAt time.Time `gorm:"column:expires_at"`
+	CreatedAt time.Time `gorm:"column:created_at"`
+}
+
+func (revokedToken) TableName() string {
+	return "revoked_token"
+}
+
+type userValidAfter struct {
+	ID               int64
+	TokensValidAfter time.Time
+}
+
+func NewStore(db *gorm.DB, logger *slog.Logger) *Store {
+	return &Store{
+		db:         db,
+		logger:     logger,
+		revoked:    map[string]time.Time{},
+		validAfter: map[int64]time.Time{},
+	}
+}
+
+// Load fills the cache with every revocation that still matters.
+func (s *Store) Load() error {
+	err := s.sync(time.Time{})
+	s.heartbeat.Beat(err)
+	return err
+}
+
+// Healthy fails when the cache couldn't be synced recently, so revocations
+// of other instances may be missed.
+func (s *Store) Healthy() error {
+	return s.heartbeat.Healthy(3 * syncInterval)
+}
+
+// Run keeps the cache in sync with the database until ctx is cancelled.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.mu.RLock()
+			// overlap a little so rows committed late aren't skipped
+			since := s.lastSync.Add(-syncInterval)
+			s.mu.RUnlock()
+
+			err := s.sync(since)
+			if err != nil {
+				s.logger.Error("syncing revocations failed", slog.Any("error", err))
+			}
+			s.heartbeat.Beat(err)
+
+			s.prune()
+		}
+	}
+}
+
+func (s *Store) sync(since time.Time) error {
+	now := time.Now()
+
+	var tokens []revokedToken
+	if err := s.db.Where("created_at >= ? AND expires_at > ?", since, now).Find(&tokens).Error; err != nil {
+		return err
+	}
+
+	var users []userValidAfter
+	if err := s.db.Table("user_account").
+		Select("id, tokens_valid_after").
+		Where("tokens_valid_after IS NOT NULL AND tokens_valid_after >= ?", since).
+		Scan(&users).Error; err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, token := range tokens {
+		s.revoked[token.JTI] = token.ExpiresAt
+	}
+
+	for _, user := range users {
+		if user.TokensValidAfter.After(s.validAfter[user.ID]) {
+			s.validAfter[user.ID] = user.TokensValidAfter
+		}
+	}
+
+	s.lastSync = now
+	return nil
+}
+
+// prune forgets revoked tokens that have expired anyway and deletes them from
+// the database.
+func (s *Store) prune() {
+	now := time.Now()
+
+	s.mu.Lock()
+	for jti, expiresAt := range s.revoked {
+		if expiresAt.Before(now) {
+			delete(s.revoked, jti)
+		}
+	}
+	s.mu.Unlock()
+
+	if err := s.db.Where("expires_at < ?", now).Delete(&revokedToken{}).Error; err != nil {
+		s.logger.Error("pruning revocations failed", slog.Any("error", err))
+	}
+}
+
+// RevokeToken rejects a single access token from now on.
+func (s *Store) RevokeToken(jti string, userId int64, expiresAt time.Time) error {
+	token := revokedToken{JTI: jti, UserID: userId, ExpiresAt: expiresAt, CreatedAt: time.Now()}
+	if err := s.db.Where(revokedToken{JTI: jti}).FirstOrCreate(&token).Error; err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.revoked[jti] = expiresAt
+	s.mu.Unlock()
+
+	return nil
+}
+
+// RevokeUser rejects every access token issued to the user until now.
+func (s *Store) RevokeUser(userId int64) error {
+	pending := s.Pending()
+	if err := pending.RevokeUser(s.db, userId); err != nil {
+		return err
+	}
+
+	pending.Apply()
+	return nil
+}
+
+// Pending collects the revocations made inside a transaction. The cache is
+// left alone until Apply, which the caller runs once the transaction
+// committed, so a rollback doesn't revoke anything.
+type Pending struct {
+	store      *Store
+	validAfter map[int64]time.Time
+}
+
+func (s *Store) Pending() *Pending {
+	return &Pending{store: s, validAfter: map[int64]time.Time{}}
+}
+
+// RevokeUser rejects every access token issued to the user until now, once
+// the transaction committed.
+//
+// Token issue times have a precision of one second, so the cut-off is the
+// next full second. Tokens signed in the same second as the revocation are
+// rejected as well, the user signs in again.
+func (p *Pending) RevokeUser(tx *gorm.DB, userId int64) error {
+	validAfter := time.Now().Truncate(time.Second).Add(time.Second)
+
+	if err := tx.Table("user_account").Where("id = ?", userId).Update("tokens_valid_after", validAfter).Error; err != nil {
+		return err
+	}
+
+	p.validAfter[userId] = validAfter
+	return nil
+}
+
+// Apply puts the collected revocations in the cache.
+func (p *Pending) Apply() {
+	p.store.mu.Lock()
+	defer p.store.mu.Unlock()
+
+	for userId, validAfter := range p.validAfter {
+		if validAfter.After(p.store.validAfter[userId]) {
+			p.store.validAfter[userId] = validAfter
+		}
+	}
+}
+
+// IsRevoked reports whether a token with the given id, issued to userId at
+// issuedAt, has been revoked.
+func (s *Store) IsRevoked(jti string, userId int64, issuedAt time.Time) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.revoked[jti]; ok && jti != "" {
+		return true
+	}
+
+	if validAfter, ok := s.validAfter[userId]; ok && issuedAt.Before(validAfter) {
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,109 @@
+package revocation
+
+import (
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+// newTestStore returns a store on a dry run database, the updates are built
+// but never sent.
+func newTestStore(t *testing.T) *Store {
+	t.Helper()
+
+	db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{
+		DryRun:                 true,
+		DisableAutomaticPing:   true,
+		SkipDefaultTransaction: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewStore(db, slog.New(slog.NewTextHandler(io.Discard, nil)))
+}
+
+func TestRevokeUserCutOff(t *testing.T) {
+	s := newTestStore(t)
+	before := time.Now().Truncate(time.Second)
+
+	if err := s.RevokeUser(1); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		userId   int64
+		issuedAt time.Time
+		want     bool
+	}{
+		{"issued a second before", 1, before.Add(-time.Second), true},
+		// iat has whole seconds, so this may have been signed just before
+		{"issued in the same second", 1, before, true},
+		{"issued after the revocation", 1, before.Add(2 * time.Second), false},
+		{"another user", 2, before.Add(-time.Second), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.IsRevoked("", tt.userId, tt.issuedAt); got != tt.want {
+				t.Errorf("IsRevoked = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPendingWaitsForApply(t *testing.T) {
+	s := newTestStore(t)
+	issuedAt := time.Now().Add(-time.Minute)
+
+	pending := s.Pending()
+	if err := pending.RevokeUser(s.db, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if s.IsRevoked("", 1, issuedAt) {
+		t.Fatal("the revocation was cached before the transaction committed")
+	}
+
+	pending.Apply()
+
+	if !s.IsRevoked("", 1, issuedAt) {
+		t.Error("the revocation wasn't cached after Apply")
+	}
+}
+
+func TestApplyKeepsTheLaterCutOff(t *testing.T) {
+	s := newTestStore(t)
+	later := time.Now().Add(time.Hour)
+	s.validAfter[1] = later
+
+	pending := s.Pending()
+	if err := pending.RevokeUser(s.db, 1); err != nil {
+		t.Fatal(err)
+	}
+	pending.Apply()
+
+	if !s.validAfter[1].Equal(later) {
+		t.Errorf("validAfter = %v, want %v", s.validAfter[1], later)
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	s := newTestStore(t)
+	s.revoked["jti"] = time.Now().Add(time.Hour)
+
+	if !s.IsRevoked("jti", 1, time.Now()) {
+		t.Error("a revoked token passed")
+	}
+	if s.IsRevoked("other", 1, time.Now()) {
+		t.Error("a token that wasn't revoked was rejected")
+	}
+	if s.IsRevoked("", 1, time.Now()) {
+		t.Error("a token without an id was rejected")
+	}
+}
@@ -0,0 +1,63 @@
+package signing
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/noctispine/blog/pkg/encryption"
+	"gorm.io/gorm"
+)
+
+// keys stored before they were encrypted are plain PEM
+const plaintextPrefix = "-----BEGIN"
+
+// encrypt seals the PEM of a private key. The kid is authenticated with it,
+// so a sealed key can't be moved to another row.
+func (r *KeyRing) encrypt(kid string, pemKey []byte) (string, error) {
+	return r.encryption.Encrypt(pemKey, kid)
+}
+
+// decrypt returns the PEM of a stored private key. Plaintext keys are
+// returned as they are until rotate encrypts them.
+func (r *KeyRing) decrypt(kid string, stored string) ([]byte, error) {
+	if strings.HasPrefix(stored, plaintextPrefix) {
+		return []byte(stored), nil
+	}
+
+	if !encryption.IsEncrypted(stored) {
+		return nil, fmt.Errorf("signing key %s is in an unknown format", kid)
+	}
+
+	pemKey, err := r.encryption.Decrypt(stored, kid)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting signing key %s failed, the secret may be wrong: %w", kid, err)
+	}
+
+	return pemKey, nil
+}
+
+// encryptPlaintextKeys encrypts keys stored before encryption was added.
+func (r *KeyRing) encryptPlaintextKeys(tx *gorm.DB) error {
+	var rows []signingKey
+	if err := tx.Where("private_key LIKE ?", plaintextPrefix+"%").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		encrypted, err := r.encrypt(row.KID, []byte(row.PrivateKey))
+		if err != nil {
+			return err
+		}
+
+		if err := tx.Model(&signingKey{}).Where("kid = ?", row.KID).Update("private_key", encrypted).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(rows) > 0 {
+		r.logger.Info("encrypted plaintext signing keys", slog.Int("keys", len(rows)))
+	}
+
+	return nil
+}
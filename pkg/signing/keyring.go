@@ -0,0 +1,404 @@
+// Package signing keeps the asymmetric keys access tokens are signed with.
+// Keys are shared by every instance through the signing_key table, where
+// the private keys are encrypted, rotated on a schedule and kept around
+// after rotation until the tokens they signed have expired.
+package signing
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/noctispine/blog/pkg/encryption"
+	"github.com/noctispine/blog/pkg/health"
+	"gorm.io/gorm"
+)
+
+const (
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+// how often keys created or rotated by other instances are picked up
+const syncInterval = time.Minute
+
+// advisory lock key held while rotating, so only one instance creates the
+// next key
+const rotationLockKey = 7_302_114_902
+
+var ErrNoSigningKey = errors.New("no signing key is active")
+var ErrUnknownKey = errors.New("token was signed with an unknown key")
+
+type signingKey struct {
+	KID         string     `gorm:"column:kid;primaryKey"`
+	Algorithm   string     `gorm:"column:algorithm"`
+	PrivateKey  string     `gorm:"column:private_key"`
+	CreatedAt   time.Time  `gorm:"column:created_at"`
+	ActivatesAt time.Time  `gorm:"column:activates_at"`
+	ExpiresAt   *time.Time `gorm:"column:expires_at"`
+}
+
+func (signingKey) TableName() string {
+	return "signing_key"
+}
+
+type Key struct {
+	ID          string
+	Algorithm   string
+	ActivatesAt time.Time
+	ExpiresAt   *time.Time
+	private     crypto.Signer
+}
+
+func (k *Key) Public() crypto.PublicKey {
+	return k.private.Public()
+}
+
+func (k *Key) method() jwt.SigningMethod {
+	if k.Algorithm == EdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+
+	return jwt.SigningMethodRS256
+}
+
+type KeyRing struct {
+	db        *gorm.DB
+	logger    *slog.Logger
+	algorithm string
+	// a new key is made this often
+	rotateEvery time.Duration
+	// a new key is published this long before it signs, so verifiers
+	// caching the JWKS see it in time
+	publishAhead time.Duration
+	// a replaced key still verifies for this long
+	retainFor time.Duration
+	// encrypts the private keys at rest
+	encryption *encryption.Key
+
+	mu   sync.RWMutex
+	keys []*Key
+
+	heartbeat health.Heartbeat
+}
+
+// NewKeyRing returns a key ring signing with the given algorithm. retainFor
+// has to be at least the lifetime of an access token. The private keys are
+// encrypted with a key derived from secret.
+func NewKeyRing(db *gorm.DB, logger *slog.Logger, algorithm string, rotateEvery time.Duration, retainFor time.Duration, secret string) (*KeyRing, error) {
+	if algorithm != RS256 && algorithm != EdDSA {
+		return nil, fmt.Errorf("unsupported signing algorithm %q", algorithm)
+	}
+
+	key, err := encryption.NewKey(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	publishAhead := time.Hour
+	if rotateEvery/2 < publishAhead {
+		publishAhead = rotateEvery / 2
+	}
+
+	return &KeyRing{
+		db:           db,
+		logger:       logger,
+		algorithm:    algorithm,
+		rotateEvery:  rotateEvery,
+		publishAhead: publishAhead,
+		retainFor:    retainFor,
+		encryption:   key,
+	}, nil
+}
+
+// Load creates the first key if there is none yet and loads every key that
+// still verifies.
+func (r *KeyRing) Load() error {
+	err := r.rotate()
+	if err == nil {
+		err = r.sync()
+	}
+
+	r.heartbeat.Beat(err)
+	return err
+}
+
+// Healthy fails when there is no key to sign with or the keys couldn't be
+// rotated or synced recently.
+func (r *KeyRing) Healthy() error {
+	if _, err := r.signingKey(); err != nil {
+		return err
+	}
+
+	return r.heartbeat.Healthy(3 * syncInterval)
+}
+
+// Run rotates the keys when they are due and picks up keys of other
+// instances until ctx is cancelled.
+func (r *KeyRing) Run(ctx context.Context) {
+	ticker := time.NewTicker(syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Load(); err != nil {
+				r.logger.Error("rotating signing keys failed", slog.Any("error", err))
+			}
+		}
+	}
+}
+
+func (r *KeyRing) sync() error {
+	var rows []signingKey
+	if err := r.db.Where("expires_at IS NULL OR expires_at > ?", time.Now()).Order("activates_at DESC").Find(&rows).Error; err != nil {
+		return err
+	}
+
+	keys := make([]*Key, 0, len(rows))
+	for _, row := range rows {
+		key, err := r.decodeKey(row)
+		if err != nil {
+			return err
+		}
+
+		keys = append(keys, key)
+	}
+
+	r.mu.Lock()
+	r.keys = keys
+	r.mu.Unlock()
+
+	return nil
+}
+
+// rotate creates the next key once the newest one is due for replacement,
+// or when the configured algorithm changed, and schedules the expiry of the
+// keys it replaces. Keys stored in plaintext are encrypted on the way.
+func (r *KeyRing) rotate() error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", rotationLockKey).Error; err != nil {
+			return err
+		}
+
+		if err := r.encryptPlaintextKeys(tx); err != nil {
+			return err
+		}
+
+		now := time.Now()
+
+		var newest signingKey
+		err := tx.Order("activates_at DESC").First(&newest).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return r.createKey(tx, now)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if !r.due(newest, now) {
+			return nil
+		}
+
+		var active int64
+		if err := tx.Model(&signingKey{}).
+			Where("activates_at <= ? AND (expires_at IS NULL OR expires_at > ?)", now, now).
+			Count(&active).Error; err != nil {
+			return err
+		}
+
+		activatesAt, replacedExpire := r.schedule(now, active > 0)
+
+		if err := tx.Model(&signingKey{}).
+			Where("expires_at IS NULL").
+			Update("expires_at", replacedExpire).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("expires_at < ?", now).Delete(&signingKey{}).Error; err != nil {
+			return err
+		}
+
+		return r.createKey(tx, activatesAt)
+	})
+}
+
+// due reports whether the newest key has to be replaced: it's about to
+// reach its rotation, counting the time the next key is published ahead, or
+// it was made for another algorithm than the configured one.
+func (r *KeyRing) due(newest signingKey, now time.Time) bool {
+	return newest.Algorithm != r.algorithm || !now.Before(newest.ActivatesAt.Add(r.rotateEvery-r.publishAhead))
+}
+
+// schedule returns when the next key starts signing and when the keys it
+// replaces stop verifying. Without a key that signs now, e.g. after a long
+// downtime, the next key can't wait to be published.
+func (r *KeyRing) schedule(now time.Time, active bool) (time.Time, time.Time) {
+	activatesAt := now.Add(r.publishAhead)
+	if !active {
+		activatesAt = now
+	}
+
+	return activatesAt, activatesAt.Add(r.retainFor)
+}
+
+// generateKey makes a private key for the algorithm and returns it PEM
+// encoded as well.
+func generateKey(algorithm string) (crypto.Signer, []byte, error) {
+	var private crypto.Signer
+	var err error
+
+	if algorithm == EdDSA {
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return private, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+func (r *KeyRing) createKey(tx *gorm.DB, activatesAt time.Time) error {
+	_, pemKey, err := generateKey(r.algorithm)
+	if err != nil {
+		return err
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	kid := hex.EncodeToString(id)
+
+	encrypted, err := r.encrypt(kid, pemKey)
+	if err != nil {
+		return err
+	}
+
+	return tx.Create(&signingKey{
+		KID:         kid,
+		Algorithm:   r.algorithm,
+		PrivateKey:  encrypted,
+		CreatedAt:   time.Now(),
+		ActivatesAt: activatesAt,
+	}).Error
+}
+
+func (r *KeyRing) decodeKey(row signingKey) (*Key, error) {
+	pemKey, err := r.decrypt(row.KID, row.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(pemKey)
+	if block == nil {
+		return nil, fmt.Errorf("signing key %s is not PEM encoded", row.KID)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("signing key %s can't sign", row.KID)
+	}
+
+	return &Key{
+		ID:          row.KID,
+		Algorithm:   row.Algorithm,
+		ActivatesAt: row.ActivatesAt,
+		ExpiresAt:   row.ExpiresAt,
+		private:     private,
+	}, nil
+}
+
+// Keys returns every key that verifies tokens, including a published key
+// that doesn't sign yet.
+func (r *KeyRing) Keys() []*Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]*Key, 0, len(r.keys))
+	for _, key := range r.keys {
+		if key.ExpiresAt == nil || key.ExpiresAt.After(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+// signingKey returns the newest key that is active.
+func (r *KeyRing) signingKey() (*Key, error) {
+	now := time.Now()
+
+	for _, key := range r.Keys() {
+		if !key.ActivatesAt.After(now) {
+			return key, nil
+		}
+	}
+
+	return nil, ErrNoSigningKey
+}
+
+// Sign signs the claims with the active key and names it in the kid header.
+func (r *KeyRing) Sign(claims jwt.Claims) (string, error) {
+	key, err := r.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method(), claims)
+	token.Header["kid"] = key.ID
+
+	if key.Algorithm == EdDSA {
+		return token.SignedString(key.private)
+	}
+
+	return token.SignedString(key.private.(*rsa.PrivateKey))
+}
+
+// Keyfunc finds the public key of a token for jwt.Parse. A token has to use
+// the algorithm its key was made for.
+func (r *KeyRing) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	for _, key := range r.Keys() {
+		if key.ID != kid {
+			continue
+		}
+
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+		}
+
+		return key.Public(), nil
+	}
+
+	return nil, ErrUnknownKey
+}
@@ -0,0 +1,260 @@
+package signing
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/noctispine/blog/pkg/encryption"
+)
+
+const testSecret = "0123456789abcdef0123456789abcdef"
+
+func newTestKeyRing(t *testing.T, algorithm string, rotateEvery time.Duration, retainFor time.Duration) *KeyRing {
+	t.Helper()
+
+	r, err := NewKeyRing(nil, slog.New(slog.NewTextHandler(io.Discard, nil)), algorithm, rotateEvery, retainFor, testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// testKey makes a key the way createKey stores it and loads it back.
+func testKey(t *testing.T, r *KeyRing, kid string, algorithm string, activatesAt time.Time, expiresAt *time.Time) *Key {
+	t.Helper()
+
+	_, pemKey, err := generateKey(algorithm)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := r.encrypt(kid, pemKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := r.decodeKey(signingKey{KID: kid, Algorithm: algorithm, PrivateKey: encrypted, ActivatesAt: activatesAt, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestNewKeyRing(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name         string
+		algorithm    string
+		rotateEvery  time.Duration
+		secret       string
+		wantErr      bool
+		publishAhead time.Duration
+	}{
+		{"RS256", RS256, 720 * time.Hour, testSecret, false, time.Hour},
+		{"EdDSA", EdDSA, 720 * time.Hour, testSecret, false, time.Hour},
+		{"short rotation publishes half way", RS256, 30 * time.Minute, testSecret, false, 15 * time.Minute},
+		{"unsupported algorithm", "HS256", 720 * time.Hour, testSecret, true, 0},
+		{"missing secret", RS256, 720 * time.Hour, "", true, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewKeyRing(nil, logger, tt.algorithm, tt.rotateEvery, time.Hour, tt.secret)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && r.publishAhead != tt.publishAhead {
+				t.Errorf("publishAhead = %v, want %v", r.publishAhead, tt.publishAhead)
+			}
+		})
+	}
+}
+
+func TestDue(t *testing.T) {
+	r := newTestKeyRing(t, RS256, 24*time.Hour, 2*time.Hour)
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		newest signingKey
+		want   bool
+	}{
+		{"fresh key", signingKey{Algorithm: RS256, ActivatesAt: now.Add(-time.Hour)}, false},
+		{"published ahead, not signing yet", signingKey{Algorithm: RS256, ActivatesAt: now.Add(time.Hour)}, false},
+		{"just before the next key is published", signingKey{Algorithm: RS256, ActivatesAt: now.Add(-23*time.Hour + time.Second)}, false},
+		{"next key is to be published", signingKey{Algorithm: RS256, ActivatesAt: now.Add(-23 * time.Hour)}, true},
+		{"long overdue", signingKey{Algorithm: RS256, ActivatesAt: now.Add(-30 * 24 * time.Hour)}, true},
+		{"algorithm changed", signingKey{Algorithm: EdDSA, ActivatesAt: now}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.due(tt.newest, now); got != tt.want {
+				t.Errorf("due = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	r := newTestKeyRing(t, RS256, 24*time.Hour, 2*time.Hour)
+	now := time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name            string
+		active          bool
+		wantActivatesAt time.Time
+		wantExpire      time.Time
+	}{
+		// verifiers caching the JWKS see the key an hour before it signs
+		// and the replaced keys outlive the tokens they signed
+		{"a key signs now", true, now.Add(time.Hour), now.Add(3 * time.Hour)},
+		{"no key signs now", false, now, now.Add(2 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activatesAt, expire := r.schedule(now, tt.active)
+			if !activatesAt.Equal(tt.wantActivatesAt) || !expire.Equal(tt.wantExpire) {
+				t.Errorf("schedule = %v, %v, want %v, %v", activatesAt, expire, tt.wantActivatesAt, tt.wantExpire)
+			}
+		})
+	}
+}
+
+func TestKeysAndSigningKey(t *testing.T) {
+	r := newTestKeyRing(t, EdDSA, 24*time.Hour, 2*time.Hour)
+	now := time.Now()
+	expired := now.Add(-time.Minute)
+	retained := now.Add(time.Hour)
+
+	next := testKey(t, r, "next", EdDSA, now.Add(time.Hour), nil)
+	current := testKey(t, r, "current", EdDSA, now.Add(-time.Hour), &retained)
+	previous := testKey(t, r, "previous", EdDSA, now.Add(-25*time.Hour), &retained)
+	old := testKey(t, r, "old", EdDSA, now.Add(-50*time.Hour), &expired)
+
+	// sync orders the keys newest first
+	r.keys = []*Key{next, current, previous, old}
+
+	var ids []string
+	for _, key := range r.Keys() {
+		ids = append(ids, key.ID)
+	}
+	if got := strings.Join(ids, ","); got != "next,current,previous" {
+		t.Errorf("Keys = %s, want the published and retained keys", got)
+	}
+
+	signing, err := r.signingKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signing.ID != "current" {
+		t.Errorf("signing key = %s, want the newest active one", signing.ID)
+	}
+
+	r.keys = []*Key{next}
+	if _, err := r.signingKey(); !errors.Is(err, ErrNoSigningKey) {
+		t.Errorf("err = %v, want ErrNoSigningKey while the only key isn't active", err)
+	}
+
+	if jwks := r.JWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "next" || jwks.Keys[0].Curve != "Ed25519" {
+		t.Errorf("JWKS = %+v, want the published key", jwks)
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for _, algorithm := range []string{RS256, EdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			r := newTestKeyRing(t, algorithm, 24*time.Hour, 2*time.Hour)
+			r.keys = []*Key{testKey(t, r, "kid-"+algorithm, algorithm, time.Now().Add(-time.Minute), nil)}
+
+			signed, err := r.Sign(jwt.RegisteredClaims{Subject: "42"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var claims jwt.RegisteredClaims
+			token, err := jwt.ParseWithClaims(signed, &claims, r.Keyfunc)
+			if err != nil || !token.Valid {
+				t.Fatalf("verifying the signed token: %v", err)
+			}
+			if claims.Subject != "42" || token.Header["kid"] != "kid-"+algorithm {
+				t.Errorf("claims = %+v, header = %v", claims, token.Header)
+			}
+
+			// a retired key no longer verifies
+			r.keys = []*Key{testKey(t, r, "another", algorithm, time.Now().Add(-time.Minute), nil)}
+			if _, err := jwt.Parse(signed, r.Keyfunc); !errors.Is(err, ErrUnknownKey) {
+				t.Errorf("err = %v, want ErrUnknownKey", err)
+			}
+		})
+	}
+}
+
+func TestKeyfuncRejectsOtherAlgorithms(t *testing.T) {
+	r := newTestKeyRing(t, RS256, 24*time.Hour, 2*time.Hour)
+	r.keys = []*Key{testKey(t, r, "rsa", RS256, time.Now().Add(-time.Minute), nil)}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{})
+	token.Header["kid"] = "rsa"
+
+	if _, err := r.Keyfunc(token); err == nil {
+		t.Error("Keyfunc accepted an HS256 token for an RS256 key")
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	r := newTestKeyRing(t, EdDSA, 24*time.Hour, 2*time.Hour)
+
+	_, pemKey, err := generateKey(EdDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encrypted, err := r.encrypt("kid", pemKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encrypted, encryption.Prefix) || strings.Contains(encrypted, "PRIVATE KEY") {
+		t.Fatalf("encrypted key = %q", encrypted)
+	}
+
+	other, err := NewKeyRing(nil, r.logger, EdDSA, time.Hour, time.Hour, "another secret of at least 32 chars")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		ring    *KeyRing
+		kid     string
+		stored  string
+		wantErr bool
+	}{
+		{"round trip", r, "kid", encrypted, false},
+		{"plaintext PEM of older rows", r, "kid", string(pemKey), false},
+		{"moved to another row", r, "other", encrypted, true},
+		{"wrong secret", other, "kid", encrypted, true},
+		{"truncated", r, "kid", encryption.Prefix + "AAAA", true},
+		{"not base64", r, "kid", encryption.Prefix + "!!!", true},
+		{"unknown format", r, "kid", "garbage", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.ring.decrypt(tt.kid, tt.stored)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != string(pemKey) {
+				t.Errorf("decrypted key differs from the original")
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package sitemap
+
+import (
+	"encoding/xml"
+	"time"
+)
+
+// MaxURLs is the most URLs the sitemap protocol allows in a single file.
+const MaxURLs = 50000
+
+const namespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type URL struct {
+	Loc     string
+	LastMod *time.Time
+}
+
+type urlSet struct {
+	XMLName xml.Name `xml:"urlset"`
+	XMLNS   string   `xml:"xmlns,attr"`
+	URLs    []entry  `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name `xml:"sitemapindex"`
+	XMLNS    string   `xml:"xmlns,attr"`
+	Sitemaps []entry  `xml:"sitemap"`
+}
+
+type entry struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+func newEntry(u URL) entry {
+	e := entry{Loc: u.Loc}
+	if u.LastMod != nil && !u.LastMod.IsZero() {
+		e.LastMod = u.LastMod.UTC().Format(time.RFC3339)
+	}
+	return e
+}
+
+// RenderURLSet encodes a sitemap listing the given pages.
+func RenderURLSet(urls []URL) ([]byte, error) {
+	doc := urlSet{XMLNS: namespace, URLs: make([]entry, len(urls))}
+	for i, u := range urls {
+		doc.URLs[i] = newEntry(u)
+	}
+
+	return marshal(doc)
+}
+
+// RenderIndex encodes a sitemap index pointing at child sitemaps.
+func RenderIndex(sitemaps []URL) ([]byte, error) {
+	doc := sitemapIndex{XMLNS: namespace, Sitemaps: make([]entry, len(sitemaps))}
+	for i, u := range sitemaps {
+		doc.Sitemaps[i] = newEntry(u)
+	}
+
+	return marshal(doc)
+}
+
+func marshal(doc interface{}) ([]byte, error) {
+	body, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), body...), nil
+}
@@ -0,0 +1,183 @@
+package totp
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// the ASCII secret "12345678901234567890" of the RFC 4226 and RFC 6238 test
+// vectors, base32 encoded
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 4226 appendix D
+	tests := []struct {
+		counter uint64
+		want    string
+	}{
+		{0, "755224"},
+		{1, "287082"},
+		{2, "359152"},
+		{3, "969429"},
+		{4, "338314"},
+		{5, "254676"},
+		{6, "287922"},
+		{7, "162583"},
+		{8, "399871"},
+		{9, "520489"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, tt.counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.counter, got, tt.want)
+		}
+	}
+}
+
+func TestCodeAt(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, cut to six digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeSecretFormats(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+	}{
+		{"lower case", strings.ToLower(rfcSecret)},
+		{"padded", rfcSecret + "===="},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Code(tt.secret, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != "287082" {
+				t.Errorf("Code = %s, want 287082", got)
+			}
+		})
+	}
+
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code accepted a secret that isn't base32")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Counter(now)
+
+	tests := []struct {
+		name        string
+		code        string
+		skew        int
+		wantCounter uint64
+		wantOK      bool
+	}{
+		{"current step", "050471", 0, current, true},
+		{"previous step within skew", mustCode(t, current-1), 1, current - 1, true},
+		{"next step within skew", mustCode(t, current+1), 1, current + 1, true},
+		{"previous step without skew", mustCode(t, current-1), 0, 0, false},
+		{"two steps back", mustCode(t, current-2), 1, 0, false},
+		{"wrong code", "000000", 1, 0, false},
+		{"too short", "50471", 1, 0, false},
+		{"too long", "0050471", 1, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := Validate(rfcSecret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || counter != tt.wantCounter {
+				t.Errorf("Validate(%s) = %d, %v, want %d, %v", tt.code, counter, ok, tt.wantCounter, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(key) != 20 {
+		t.Errorf("secret has %d bytes, want 20", len(key))
+	}
+
+	other, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other == secret {
+		t.Error("GenerateSecret returned the same secret twice")
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("My Blog", "ada@example.com", rfcSecret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if uri.Scheme != "otpauth" || uri.Host != "totp" {
+		t.Errorf("URI = %s, want otpauth://totp/...", uri)
+	}
+	if uri.Path != "/My Blog:ada@example.com" {
+		t.Errorf("label = %q", uri.Path)
+	}
+
+	query := uri.Query()
+	for name, want := range map[string]string{
+		"secret":    rfcSecret,
+		"issuer":    "My Blog",
+		"algorithm": "SHA1",
+		"digits":    "6",
+		"period":    "30",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func mustCode(t *testing.T, counter uint64) string {
+	t.Helper()
+
+	code, err := Code(rfcSecret, counter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
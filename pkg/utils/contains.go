@@ -5,7 +5,6 @@ import (
 	"strings"
 
 	"github.com/jackc/pgconn"
-	"github.com/jackc/pgerrcode"
 )
 
 
@@ -25,7 +24,7 @@ func ConstructSlug(s string) string {
 func CheckPostgreError(err error, code string) bool {
     var pgErr *pgconn.PgError
     if errors.As(err, &pgErr) {
-        return pgErr.Code == pgerrcode.UniqueViolation
+        return pgErr.Code == code
     }
 
     return false